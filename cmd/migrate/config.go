@@ -2,31 +2,62 @@ package main
 
 import (
 	"flag"
+	"os"
 )
 
 type Config struct {
-	Version   bool
-	DSN       string
-	Dir       string
-	Schema    string
-	TableName string
+	Command       string
+	Version       bool
+	DSN           string
+	Dir           string
+	Schema        string
+	TableName     string
+	FailOn        string
+	ServerVersion int
+	Break         bool
+	Clear         bool
+	Name          string
 }
 
 var defaults = Config{
-	Version:   false,
-	DSN:       "postgres://localhost:5432?sslmode=disable",
-	Dir:       ".",
-	Schema:    "",
-	TableName: "",
+	Command:       "",
+	Version:       false,
+	DSN:           "postgres://localhost:5432?sslmode=disable",
+	Dir:           ".",
+	Schema:        "",
+	TableName:     "",
+	FailOn:        "warning",
+	ServerVersion: 0,
+	Break:         false,
+	Clear:         false,
+	Name:          "",
+}
+
+// commands that may be given as the first argument.
+var commands = map[string]bool{
+	"lint":    true,
+	"lock":    true,
+	"resolve": true,
+	"restore": true,
 }
 
 func NewConfig() (*Config, error) {
 	config := defaults
+
+	args := os.Args[1:]
+	if len(args) > 0 && commands[args[0]] {
+		config.Command, args = args[0], args[1:]
+	}
+
 	flag.StringVar(&config.DSN, "dsn", defaults.DSN, "Migration DSN.")
 	flag.StringVar(&config.Dir, "dir", defaults.Dir, "Migration directory.")
 	flag.StringVar(&config.Schema, "schema", defaults.Schema, "Schema name for migrations table (Postgres/DuckDB only).")
 	flag.StringVar(&config.TableName, "table", defaults.TableName, "Custom name for migrations table.")
-	flag.Parse()
+	flag.StringVar(&config.FailOn, "fail-on", defaults.FailOn, "Lint exits non-zero on findings of at least this severity (info, warning, error).")
+	flag.IntVar(&config.ServerVersion, "server-version", defaults.ServerVersion, "Lint for this major server version, e.g. 10 (default a current server).")
+	flag.BoolVar(&config.Break, "break", defaults.Break, "Lock breaks the migration lock instead of showing it.")
+	flag.BoolVar(&config.Clear, "clear", defaults.Clear, "Resolve clears the dirty migration instead of marking it completed.")
+	flag.CommandLine.Parse(args)
 
 	if flag.Arg(0) != "" {
 		config.DSN = flag.Arg(0)
@@ -34,6 +65,9 @@ func NewConfig() (*Config, error) {
 	if flag.Arg(1) != "" {
 		config.Dir = flag.Arg(1)
 	}
+	if flag.Arg(2) != "" {
+		config.Name = flag.Arg(2)
+	}
 
 	return &config, nil
 }
@@ -1,17 +1,20 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/shanna/migrate"
 	_ "github.com/shanna/migrate/driver/clickhouse"
 	_ "github.com/shanna/migrate/driver/duckdb"
 	_ "github.com/shanna/migrate/driver/postgres"
 	_ "github.com/shanna/migrate/driver/sqlite"
+	"github.com/shanna/migrate/lint"
 )
 
 var (
@@ -31,6 +34,10 @@ func main() {
 	driver, err := url.Parse(config.DSN)
 	exitOnError(err)
 
+	if config.Command == "lint" {
+		os.Exit(lintDir(config, driver))
+	}
+
 	var opts []migrate.Option
 	if config.Schema != "" {
 		opts = append(opts, migrate.WithSchema(config.Schema))
@@ -42,12 +49,88 @@ func main() {
 	migrator, err := migrate.New(driver.Scheme, config.DSN, opts...)
 	exitOnError(err)
 
+	if config.Command == "lock" {
+		exitOnError(lock(config, migrator))
+		os.Exit(0)
+	}
+
+	if config.Command == "restore" {
+		path, err := migrator.Restore()
+		exitOnError(err)
+		fmt.Printf("restored\t%s\n", path)
+		os.Exit(0)
+	}
+
+	if config.Command == "resolve" {
+		exitOnError(resolve(config, migrator))
+		os.Exit(0)
+	}
+
 	if err := migrator.Dir(config.Dir); err != nil {
 		log.Printf("error\t%s\n", err)
 		os.Exit(1)
 	}
 }
 
+// lintDir prints findings and returns the exit code. The DSN may be a bare
+// driver name since lint doesn't connect.
+func lintDir(config *Config, driver *url.URL) int {
+	name := driver.Scheme
+	if name == "" {
+		name = config.DSN
+	}
+
+	failOn, err := lint.ParseSeverity(config.FailOn)
+	exitOnError(err)
+
+	var opts []lint.Option
+	if config.ServerVersion > 0 {
+		opts = append(opts, lint.WithServerVersion(config.ServerVersion))
+	}
+
+	findings, err := migrate.Lint(name, config.Dir, opts...)
+	exitOnError(err)
+
+	code := 0
+	for _, finding := range findings {
+		fmt.Println(finding)
+		if finding.Severity >= failOn {
+			code = 1
+		}
+	}
+	return code
+}
+
+// lock prints or breaks the database migration lock.
+func lock(config *Config, migrator *migrate.Migrate) error {
+	if config.Break {
+		return migrator.BreakLock()
+	}
+
+	info, err := migrator.LockInfo()
+	if err != nil {
+		return err
+	}
+	if !info.Held(time.Now()) {
+		fmt.Println("unlocked")
+		return nil
+	}
+	fmt.Printf("locked\towner:%s host:%s acquired:%s expires:%s\n", info.Owner, info.Host, info.Acquired.Format(time.RFC3339), info.Expires.Format(time.RFC3339))
+	return nil
+}
+
+// resolve marks a dirty migration completed, or clears it, after it has been
+// repaired by hand.
+func resolve(config *Config, migrator *migrate.Migrate) error {
+	if config.Name == "" {
+		return errors.New("resolve: migration name required")
+	}
+	if config.Clear {
+		return migrator.Clear(config.Name)
+	}
+	return migrator.Resolve(config.Dir, config.Name)
+}
+
 func exitOnError(err error) {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n\n", err)
@@ -0,0 +1,131 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoBackup is returned by Restore when there is no snapshot to restore.
+var ErrNoBackup = errors.New("no backup")
+
+// Restorer is implemented by drivers that snapshot a database file before
+// each run so the latest snapshot can be put back.
+type Restorer interface {
+	// Restore replaces the database with its latest snapshot, returning the
+	// snapshot's path. The driver is closed afterwards.
+	Restore() (string, error)
+}
+
+// backupTime sorts lexically.
+const backupTime = "20060102T150405.000000000Z"
+
+// Backups keeps timestamped snapshots of a database file in Dir, pruning all
+// but the Keep most recent. Keep zero keeps every snapshot.
+type Backups struct {
+	Dir  string
+	Keep int
+}
+
+// Path returns the snapshot path for database at now.
+func (b Backups) Path(database string, now time.Time) string {
+	base, ext := backupName(database)
+	return filepath.Join(b.Dir, base+"-"+now.UTC().Format(backupTime)+ext)
+}
+
+// List returns the snapshots of database, oldest first.
+func (b Backups) List(database string) ([]string, error) {
+	entries, err := os.ReadDir(b.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("backups: %w", err)
+	}
+
+	base, ext := backupName(database)
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, base+"-")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, ext); !ok || len(stamp) != len(backupTime) {
+			continue
+		}
+		paths = append(paths, filepath.Join(b.Dir, name))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Latest returns the most recent snapshot of database.
+func (b Backups) Latest(database string) (string, error) {
+	paths, err := b.List(database)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", fmt.Errorf("%w of %s in %s", ErrNoBackup, database, b.Dir)
+	}
+	return paths[len(paths)-1], nil
+}
+
+// Prune removes all but the Keep most recent snapshots of database.
+func (b Backups) Prune(database string) error {
+	if b.Keep <= 0 {
+		return nil
+	}
+	paths, err := b.List(database)
+	if err != nil {
+		return err
+	}
+	for len(paths) > b.Keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return fmt.Errorf("backups prune: %w", err)
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+func backupName(database string) (string, string) {
+	base := filepath.Base(database)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext), ext
+}
+
+// CopyFile copies src over dst through a temporary file so dst is never left
+// half written.
+func CopyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
@@ -0,0 +1,59 @@
+package driver_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shanna/migrate/driver"
+)
+
+func TestBackups(t *testing.T) {
+	dir := t.TempDir()
+	backups := driver.Backups{Dir: filepath.Join(dir, "backups"), Keep: 2}
+
+	if _, err := backups.Latest("/data/app.db"); !errors.Is(err, driver.ErrNoBackup) {
+		t.Fatalf("expected no backup, got %v", err)
+	}
+
+	if err := os.MkdirAll(backups.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var want []string
+	for i := range 3 {
+		path := backups.Path("/data/app.db", start.Add(time.Duration(i)*time.Hour))
+		if err := os.WriteFile(path, []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, path)
+	}
+	os.WriteFile(filepath.Join(backups.Dir, "other-20240102T030405.000000000Z.db"), nil, 0644)
+
+	if err := backups.Prune("/data/app.db"); err != nil {
+		t.Fatalf("prune %s", err)
+	}
+	got, err := backups.List("/data/app.db")
+	if err != nil {
+		t.Fatalf("list %s", err)
+	}
+	if diff := cmp.Diff(want[1:], got); diff != "" {
+		t.Errorf("backups mismatch (-want +got):\n%s", diff)
+	}
+
+	latest, err := backups.Latest("/data/app.db")
+	if err != nil || latest != want[2] {
+		t.Fatalf("expected latest %s, got %s %v", want[2], latest, err)
+	}
+
+	restored := filepath.Join(dir, "app.db")
+	if err := driver.CopyFile(restored, latest); err != nil {
+		t.Fatalf("copy %s", err)
+	}
+	if data, _ := os.ReadFile(restored); !cmp.Equal(data, []byte{2}) {
+		t.Errorf("expected latest backup contents, got %v", data)
+	}
+}
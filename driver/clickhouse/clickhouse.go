@@ -2,22 +2,24 @@ package clickhouse
 
 import (
 	"context"
-	"crypto/sha512"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
 )
 
 // migrationMutex prevents concurrent migrations within the same process.
-// ClickHouse doesn't support traditional transactions for DDL, so external
-// coordination is required for multi-process scenarios.
+// ClickHouse doesn't support traditional transactions for DDL, so runs in
+// separate processes are serialised by a lease lock table, see lock.go.
 var migrationMutex sync.Mutex
 
 func init() {
@@ -28,25 +30,38 @@ type migrate struct {
 	name      string
 	completed time.Time
 	checksum  string
+	dirty     bool // Started but never completed.
 }
 
 type ClickHouse struct {
 	db        *sql.DB
+	owned     bool // Close db on Commit or Rollback.
 	database  string
 	tableName string
 	logger    driver.Logger
 	locked    bool
+	host      string
+
+	owner         string
+	lease         time.Duration
+	lockWait      time.Duration
+	stopHeartbeat func()
+	lost          atomic.Pointer[driver.LockInfo] // Holder once the lock was lost.
+
+	cluster    string
+	clusterDDL ClusterDDL
+	ddlTimeout time.Duration
+
+	mutationTimeout time.Duration
+	settings        map[string]any
 }
 
 func New(dsn string, opts ...driver.Option) (driver.Migrator, error) {
-	config := &driver.Config{
-		Schema:    driver.DefaultSchema,
-		TableName: driver.DefaultTableName,
-		Logger:    slog.Default(),
-	}
-	for _, opt := range opts {
-		opt(config)
+	dsn, dsnOpts, err := dsnOptions(dsn)
+	if err != nil {
+		return nil, err
 	}
+	opts = append(dsnOpts, opts...)
 
 	conn, err := sql.Open("clickhouse", dsn)
 	if err != nil {
@@ -58,14 +73,58 @@ func New(dsn string, opts ...driver.Option) (driver.Migrator, error) {
 		return nil, fmt.Errorf("ping: %w", err)
 	}
 
-	c := &ClickHouse{
-		db:        conn,
+	c := newClickHouse(conn, opts)
+	c.owned = true
+	return c, nil
+}
+
+// NewFromDB creates a migrator using an existing database handle. The caller
+// keeps ownership and Commit or Rollback never close it.
+func NewFromDB(db *sql.DB, opts ...driver.Option) (driver.Migrator, error) {
+	if db == nil {
+		return nil, errors.New("clickhouse: nil database")
+	}
+	return newClickHouse(db, opts), nil
+}
+
+func newClickHouse(db *sql.DB, opts []driver.Option) *ClickHouse {
+	config := &driver.Config{
+		Schema:    driver.DefaultSchema,
+		TableName: driver.DefaultTableName,
+		Logger:    slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	options := configOptions(config)
+
+	host, _ := os.Hostname()
+
+	return &ClickHouse{
+		db:        db,
+		host:      host,
 		database:  config.Schema,
 		tableName: config.TableName,
 		logger:    config.Logger,
+		owner:     lockOwner(),
+		lease:     options.lease,
+		lockWait:  options.lockWait,
+
+		cluster:    options.cluster,
+		clusterDDL: options.clusterDDL,
+		ddlTimeout: options.ddlTimeout,
+
+		mutationTimeout: options.mutationTimeout,
+		settings:        options.settings,
 	}
+}
 
-	return c, nil
+// close an owned database.
+func (c *ClickHouse) close() {
+	if c.owned {
+		c.db.Close()
+	}
 }
 
 func (c *ClickHouse) qualifiedTableName() string {
@@ -73,28 +132,44 @@ func (c *ClickHouse) qualifiedTableName() string {
 }
 
 func (c *ClickHouse) createDatabaseSQL() string {
-	return fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s`, c.database)
+	return fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s%s`, c.database, c.onCluster())
 }
 
+// createTableSQL holds a row per migration. Rows are only ever inserted, a
+// dirty row before a migration runs and a completed row after, with a newer
+// version each time so reads with FINAL see the latest row per name however
+// many retries or concurrent runs inserted.
 func (c *ClickHouse) createTableSQL() string {
-	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	return c.createTableNameSQL(c.tableName)
+}
+
+// createTableNameSQL creates a history table called name in the database.
+func (c *ClickHouse) createTableNameSQL(name string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s%s (
   name String,
   checksum String,
-  completed DateTime DEFAULT now()
-) ENGINE = MergeTree()
-ORDER BY name`, c.qualifiedTableName())
+  completed DateTime DEFAULT now(),
+  dirty UInt8 DEFAULT 0,
+  host String DEFAULT '',
+  duration_ms UInt64 DEFAULT 0,
+  version UInt64 DEFAULT 0
+) ENGINE = %s
+ORDER BY name`, c.database+"."+name, c.onCluster(), c.engine(name, "ReplacingMergeTree", "version"))
 }
 
 func (c *ClickHouse) selectMigrationSQL() string {
 	return fmt.Sprintf(`
-SELECT name, completed, checksum
-FROM %s
-WHERE name = ?;
+SELECT name, completed, checksum, dirty
+FROM %s FINAL
+WHERE name = ?
 `, c.qualifiedTableName())
 }
 
 func (c *ClickHouse) insertMigrationSQL() string {
-	return fmt.Sprintf(`INSERT INTO %s (name, checksum) VALUES (?, ?)`, c.qualifiedTableName())
+	return fmt.Sprintf(`
+INSERT INTO %s (name, checksum, dirty, host, duration_ms, version)
+SELECT ?, ?, ?, ?, ?, toUnixTimestamp64Nano(now64(9))
+`, c.qualifiedTableName())
 }
 
 func (c *ClickHouse) Begin() error {
@@ -108,19 +183,51 @@ func (c *ClickHouse) Begin() error {
 		return fmt.Errorf("ping: %w", err)
 	}
 
-	// Setup creates database/table if needed.
-	// ClickHouse doesn't support multi-statements or transactions for DDL,
-	// so we execute setup statements separately.
-	if _, err := c.db.ExecContext(ctx, c.createDatabaseSQL()); err != nil {
+	if err := c.setup(ctx); err != nil {
+		c.unlock()
+		return err
+	}
+
+	if err := c.lock(ctx); err != nil {
 		c.unlock()
+		return err
+	}
+
+	if err := c.upgrade(ctx); err != nil {
+		c.release(ctx)
+		c.unlock()
+		return err
+	}
+
+	// Without transactional DDL a failed migration may have half run, so
+	// nothing else runs until it has been repaired and resolved.
+	if err := c.dirty(ctx); err != nil {
+		c.release(ctx)
+		c.unlock()
+		return err
+	}
+
+	return nil
+}
+
+// setup creates the database and tables if needed.
+// ClickHouse doesn't support multi-statements or transactions for DDL,
+// so we execute setup statements separately.
+func (c *ClickHouse) setup(ctx context.Context) error {
+	ctx = c.ddlContext(ctx)
+
+	if _, err := c.db.ExecContext(ctx, c.createDatabaseSQL()); err != nil {
 		return fmt.Errorf("setup database: %w", err)
 	}
 
 	if _, err := c.db.ExecContext(ctx, c.createTableSQL()); err != nil {
-		c.unlock()
 		return fmt.Errorf("setup table: %w", err)
 	}
 
+	if _, err := c.db.ExecContext(ctx, c.createLockTableSQL()); err != nil {
+		return fmt.Errorf("setup lock table: %w", err)
+	}
+
 	return nil
 }
 
@@ -132,57 +239,140 @@ func (c *ClickHouse) unlock() {
 }
 
 func (c *ClickHouse) Rollback() error {
-	defer c.db.Close()
+	defer c.close()
 	defer c.unlock()
+	if err := c.release(context.Background()); err != nil {
+		c.logger.Error("migrate rollback", "driver", "clickhouse", "error", err)
+	}
 	// ClickHouse doesn't support rolling back DDL statements.
 	// Any migrations that ran are permanent.
 	return fmt.Errorf("clickhouse does not support rollback; DDL changes are permanent")
 }
 
 func (c *ClickHouse) Commit() error {
-	defer c.db.Close()
+	defer c.close()
 	defer c.unlock()
-	// No-op: ClickHouse commits DDL immediately.
-	return nil
+	// ClickHouse commits DDL immediately so there is only the lock to release.
+	return c.release(context.Background())
+}
+
+// exec a migration statement. Mutations such as ALTER TABLE ... UPDATE run in
+// the background so any it creates are waited on before the next statement.
+func (c *ClickHouse) exec(ctx context.Context, sql string) error {
+	database, table := mutationTable(sql)
+	if table == "" || c.mutationTimeout == 0 {
+		_, err := c.db.ExecContext(c.statementContext(ctx), sql)
+		return err
+	}
+
+	start, err := c.serverTime(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := c.db.ExecContext(c.statementContext(ctx), sql); err != nil {
+		return err
+	}
+	return c.waitMutations(ctx, database, table, start)
 }
 
 func (c *ClickHouse) Migrate(name string, data io.Reader) error {
 	ctx := context.Background()
 
-	checksum := sha512.New()
-	reader := io.TeeReader(data, checksum)
-	statements, err := io.ReadAll(reader)
-	if err != nil {
-		return fmt.Errorf("read: %w", err)
+	stream := driver.NewStream(data, statement.ClickHouse)
+
+	skip, err := c.skip(ctx, name, stream.Checksum)
+	if err != nil || skip {
+		return err
 	}
 
-	rows, err := c.db.QueryContext(ctx, c.selectMigrationSQL(), name)
-	if err != nil {
-		return fmt.Errorf("schema_migrations select previous: %w", err)
+	if err := c.holding(ctx); err != nil {
+		return err
+	}
+
+	if err := c.dirtyRow(ctx, name); err != nil {
+		return err
 	}
-	defer rows.Close()
 
-	if rows.Next() {
-		previous := migrate{}
-		err = rows.Scan(&previous.name, &previous.completed, &previous.checksum)
+	// ON CLUSTER tasks are matched by the server's clock, not ours, so clock
+	// skew can't miss this run's tasks or wait on older ones.
+	var queued time.Time
+	if c.cluster != "" {
+		if queued, err = c.serverTime(ctx); err != nil {
+			return err
+		}
+	}
+
+	// ClickHouse rejects multi-statement queries so run them one at a time.
+	start := time.Now()
+	for stream.Scan() {
+		stmt := stream.Statement()
+		sql, err := c.clusterStatement(stmt)
 		if err != nil {
-			return fmt.Errorf("schema_migrations scan previous: %w", err)
+			c.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "clickhouse", "error", err, "statement", stmt.Index+1, "line", stmt.Line, "sql", stmt.SQL)
+			return err
 		}
-		if base64.StdEncoding.EncodeToString(checksum.Sum(nil)) != previous.checksum {
-			return fmt.Errorf("%q has been altered since it was run on %s", previous.name, previous.completed)
+		if err := c.exec(ctx, sql); err != nil {
+			c.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "clickhouse", "error", err, "statement", stmt.Index+1, "line", stmt.Line, "sql", stmt.SQL)
+			return fmt.Errorf("statement %d (line %d): %w", stmt.Index+1, stmt.Line, err)
 		}
+	}
 
-		c.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "clickhouse", "completed", previous.completed)
-		return nil
+	if err := stream.Err(); err != nil {
+		return fmt.Errorf("read: %w", err)
 	}
-	rows.Close()
 
-	if _, err := c.db.ExecContext(ctx, string(statements)); err != nil {
-		c.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "clickhouse", "error", err, "sql", string(statements))
+	if err := c.waitDistributedDDL(ctx, queued); err != nil {
+		c.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "clickhouse", "error", err)
 		return err
 	}
 
-	if _, err = c.db.ExecContext(ctx, c.insertMigrationSQL(), name, base64.StdEncoding.EncodeToString(checksum.Sum(nil))); err != nil {
+	return c.record(ctx, name, stream.Checksum, start)
+}
+
+// skip reports whether name has already run, failing if it has been altered
+// since or was left dirty.
+func (c *ClickHouse) skip(ctx context.Context, name string, checksum func() ([]byte, error)) (bool, error) {
+	previous, err := c.previous(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if previous == nil {
+		return false, nil
+	}
+
+	if previous.dirty {
+		return false, &DirtyError{Name: previous.name, Started: previous.completed}
+	}
+
+	sum, err := checksum()
+	if err != nil {
+		return false, fmt.Errorf("read: %w", err)
+	}
+	if base64.StdEncoding.EncodeToString(sum) != previous.checksum {
+		return false, fmt.Errorf("%q has been altered since it was run on %s", previous.name, previous.completed)
+	}
+
+	c.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "clickhouse", "completed", previous.completed)
+	return true, nil
+}
+
+// dirtyRow records the attempt first so a failure part way through is never
+// mistaken for a migration that didn't run.
+func (c *ClickHouse) dirtyRow(ctx context.Context, name string) error {
+	if _, err := c.db.ExecContext(ctx, c.insertMigrationSQL(), name, "", 1, c.host, 0); err != nil {
+		return fmt.Errorf("schema_migrations insert dirty: %w", err)
+	}
+	return nil
+}
+
+// record name as completed in the history table.
+func (c *ClickHouse) record(ctx context.Context, name string, checksum func() ([]byte, error), start time.Time) error {
+	sum, err := checksum()
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	if _, err = c.db.ExecContext(ctx, c.insertMigrationSQL(), name, base64.StdEncoding.EncodeToString(sum), 0, c.host, time.Since(start).Milliseconds()); err != nil {
 		return fmt.Errorf("schema_migrations insert: %w", err)
 	}
 
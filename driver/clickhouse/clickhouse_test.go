@@ -1,15 +1,20 @@
 package clickhouse_test
 
 import (
+	"crypto/sha512"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ory/dockertest"
+	mdriver "github.com/shanna/migrate/driver"
 	driver "github.com/shanna/migrate/driver/clickhouse"
 )
 
@@ -64,7 +69,8 @@ func TestClickHouseMigrateCommit(t *testing.T) {
 	var migrations = []struct{ name, sql string }{
 		{`migrate commit: create table sql`, `CREATE TABLE IF NOT EXISTS default.commit_test (id UInt64, name String) ENGINE = MergeTree() ORDER BY id`},
 		{`migrate commit: insert sql`, `INSERT INTO default.commit_test (id, name) VALUES (1, 'woot')`},
-		{`migrate commit: multiple sql statements`, `SELECT 1`},
+		{`migrate commit: multiple sql statements`, `SELECT 1; SELECT * FROM default.commit_test LIMIT 1`},
+		{`migrate commit: multiple create table sql`, "CREATE TABLE IF NOT EXISTS default.commit_a (id UInt64) ENGINE = MergeTree() ORDER BY id;\nCREATE TABLE IF NOT EXISTS default.commit_b (id UInt64) ENGINE = MergeTree() ORDER BY id;\n"},
 	}
 
 	if err = migrator.Begin(); err != nil {
@@ -161,3 +167,366 @@ func TestClickHouseMigrateChecksumCollision(t *testing.T) {
 		t.Fatalf("commit %s", err)
 	}
 }
+
+func TestClickHouseMigrateStatementError(t *testing.T) {
+	migrator, err := driver.New(config)
+	if err != nil {
+		t.Skipf("clickhouse connect %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	err = migrator.Migrate(`migrate statement error`, strings.NewReader("SELECT 1;\nSELECT * FROM default.missing_table;\nSELECT 3;"))
+	if err == nil {
+		t.Fatalf("expected missing table error")
+	}
+	if !strings.Contains(err.Error(), "statement 2 (line 2)") {
+		t.Fatalf("expected error to name statement 2 on line 2, got %s", err)
+	}
+	migrator.Rollback()
+
+	clearDirty(t, `migrate statement error`)
+}
+
+// clearDirty clears a dirty migration left behind by a test so later runs can Begin.
+func clearDirty(t *testing.T, name string) {
+	t.Helper()
+	migrator, err := driver.New(config)
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	if err := migrator.(mdriver.Resolver).Clear(name); err != nil {
+		t.Fatalf("clear %s", err)
+	}
+}
+
+func TestClickHouseDirty(t *testing.T) {
+	const (
+		name = `migrate dirty`
+		sql  = "CREATE TABLE IF NOT EXISTS default.dirty (id UInt64) ENGINE = Memory;\nSELECT * FROM default.missing_table;"
+	)
+
+	migrator, err := driver.New(config)
+	if err != nil {
+		t.Skipf("clickhouse connect %s", err)
+	}
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate(name, strings.NewReader(sql)); err == nil {
+		t.Fatalf("expected missing table error")
+	}
+	migrator.Rollback()
+
+	second, err := driver.New(config)
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	err = second.Begin()
+	var dirtyErr *driver.DirtyError
+	if !errors.Is(err, driver.ErrDirty) || !errors.As(err, &dirtyErr) || dirtyErr.Name != name {
+		t.Fatalf("expected dirty error naming %q, got %v", name, err)
+	}
+
+	// Repaired by hand, so mark it completed and the next run skips it.
+	resolver, err := driver.New(config)
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	if err = resolver.(mdriver.Resolver).Resolve(name, strings.NewReader(sql)); err != nil {
+		t.Fatalf("resolve %s", err)
+	}
+	if err = resolver.(mdriver.Resolver).Resolve(name, strings.NewReader(sql)); !errors.Is(err, mdriver.ErrNotDirty) {
+		t.Fatalf("expected not dirty error, got %v", err)
+	}
+
+	third, err := driver.New(config)
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	if err = third.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = third.Migrate(name, strings.NewReader(sql)); err != nil {
+		t.Fatalf("migrate resolved %s", err)
+	}
+	if err = third.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+}
+
+func TestClickHouseLock(t *testing.T) {
+	first, err := driver.New(config)
+	if err != nil {
+		t.Skipf("clickhouse connect %s", err)
+	}
+	if err = first.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	second, err := driver.New(config, driver.WithLockWait(0))
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	err = second.Begin()
+	var lockErr *driver.LockError
+	if !errors.Is(err, driver.ErrLocked) || !errors.As(err, &lockErr) {
+		t.Fatalf("expected lock error, got %v", err)
+	}
+
+	inspect, err := driver.New(config)
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	locker := inspect.(mdriver.Locker)
+	info, err := locker.LockInfo()
+	if err != nil {
+		t.Fatalf("lock info %s", err)
+	}
+	if info.Owner != lockErr.Owner || !info.Held(time.Now()) {
+		t.Fatalf("expected lock held by %s, got %+v", lockErr.Owner, info)
+	}
+
+	if err = locker.BreakLock(); err != nil {
+		t.Fatalf("break lock %s", err)
+	}
+
+	third, err := driver.New(config, driver.WithLockWait(0))
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	if err = third.Begin(); err != nil {
+		t.Fatalf("begin after break %s", err)
+	}
+	if err = third.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	// The first run notices its lock was broken and leaves it alone.
+	if err = first.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+}
+
+func TestClickHouseLockLost(t *testing.T) {
+	first, err := driver.New(config)
+	if err != nil {
+		t.Skipf("clickhouse connect %s", err)
+	}
+	if err = first.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	// Another run takes the lock over once it has been broken.
+	inspect, err := driver.New(config)
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	if err = inspect.(mdriver.Locker).BreakLock(); err != nil {
+		t.Fatalf("break lock %s", err)
+	}
+	second, err := driver.New(config, driver.WithLockWait(0))
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	if err = second.Begin(); err != nil {
+		t.Fatalf("begin after break %s", err)
+	}
+	defer second.Commit()
+
+	err = first.Migrate("lock lost", strings.NewReader(`SELECT 1`))
+	var lockErr *driver.LockError
+	if !errors.Is(err, driver.ErrLocked) || !errors.As(err, &lockErr) {
+		t.Fatalf("expected lock lost error, got %v", err)
+	}
+	first.Rollback()
+}
+
+func TestClickHouseMutation(t *testing.T) {
+	migrator, err := driver.New(config, driver.WithSettings(map[string]any{"mutations_sync": 0}))
+	if err != nil {
+		t.Skipf("clickhouse connect %s", err)
+	}
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	err = migrator.Migrate(`migrate mutation`, strings.NewReader(`
+CREATE TABLE default.mutation (id UInt64, name String) ENGINE = MergeTree ORDER BY id;
+INSERT INTO default.mutation SELECT number, 'old' FROM numbers(1000);
+ALTER TABLE default.mutation UPDATE name = 'new' WHERE 1;
+`))
+	if err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+
+	// Waited on, so the mutation is done before the migration is recorded.
+	db, err := sql.Open("clickhouse", config)
+	if err != nil {
+		t.Fatalf("sql open %s", err)
+	}
+	defer db.Close()
+	var old uint64
+	if err = db.QueryRow(`SELECT count() FROM default.mutation WHERE name = 'old'`).Scan(&old); err != nil {
+		t.Fatalf("select %s", err)
+	}
+	if old != 0 {
+		t.Errorf("expected mutation done, %d rows not updated", old)
+	}
+
+	err = migrator.Migrate(`migrate mutation fail`, strings.NewReader(`
+ALTER TABLE default.mutation UPDATE name = toString(throwIf(id > 0, 'mutation failed')) WHERE 1;
+`))
+	if err == nil || !strings.Contains(err.Error(), "mutation failed") {
+		t.Fatalf("expected mutation failure reason, got %v", err)
+	}
+	migrator.Rollback()
+
+	db.Exec(`KILL MUTATION WHERE database = 'default' AND table = 'mutation'`)
+	clearDirty(t, `migrate mutation fail`)
+}
+
+func TestClickHouseUpgradeHistory(t *testing.T) {
+	const migration = "SELECT 1"
+
+	db, err := sql.Open("clickhouse", config)
+	if err != nil {
+		t.Skipf("sql open %s", err)
+	}
+	defer db.Close()
+
+	// History table as created by earlier versions, with a duplicate row.
+	checksum := sha512.Sum512([]byte(migration))
+	for _, query := range []string{
+		`CREATE DATABASE IF NOT EXISTS upgrade`,
+		`CREATE TABLE upgrade.schema_migrations (name String, checksum String, completed DateTime DEFAULT now()) ENGINE = MergeTree() ORDER BY name`,
+		`INSERT INTO upgrade.schema_migrations (name, checksum) VALUES ('001.sql', '` + base64.StdEncoding.EncodeToString(checksum[:]) + `')`,
+		`INSERT INTO upgrade.schema_migrations (name, checksum) VALUES ('001.sql', '` + base64.StdEncoding.EncodeToString(checksum[:]) + `')`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("setup %s", err)
+		}
+	}
+
+	migrator, err := driver.New(config, mdriver.WithSchema("upgrade"))
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate("001.sql", strings.NewReader(migration)); err != nil {
+		t.Fatalf("migrate already run %s", err)
+	}
+	if err = migrator.Migrate("002.sql", strings.NewReader(migration)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	var engine string
+	if err = db.QueryRow(`SELECT engine FROM system.tables WHERE database = 'upgrade' AND name = 'schema_migrations'`).Scan(&engine); err != nil {
+		t.Fatalf("select engine %s", err)
+	}
+	if engine != "ReplacingMergeTree" {
+		t.Errorf("expected ReplacingMergeTree history, got %s", engine)
+	}
+
+	var rows uint64
+	if err = db.QueryRow(`SELECT count() FROM upgrade.schema_migrations FINAL WHERE host != '' AND dirty = 0`).Scan(&rows); err != nil {
+		t.Fatalf("select history %s", err)
+	}
+	if rows != 1 {
+		t.Errorf("expected one completed row with a host, got %d", rows)
+	}
+}
+
+func TestClickHouseUpgradeHistoryResume(t *testing.T) {
+	const migration = "SELECT 1"
+
+	db, err := sql.Open("clickhouse", config)
+	if err != nil {
+		t.Skipf("sql open %s", err)
+	}
+	defer db.Close()
+
+	// An upgrade that crashed after dropping the old table leaves the history
+	// only in the scratch table.
+	checksum := sha512.Sum512([]byte(migration))
+	for _, query := range []string{
+		`CREATE DATABASE IF NOT EXISTS resume`,
+		`CREATE TABLE resume.schema_migrations_upgrade (name String, checksum String, completed DateTime DEFAULT now(), dirty UInt8 DEFAULT 0, host String DEFAULT '', duration_ms UInt64 DEFAULT 0, version UInt64 DEFAULT 0) ENGINE = ReplacingMergeTree(version) ORDER BY name`,
+		`INSERT INTO resume.schema_migrations_upgrade (name, checksum) VALUES ('001.sql', '` + base64.StdEncoding.EncodeToString(checksum[:]) + `')`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("setup %s", err)
+		}
+	}
+
+	migrator, err := driver.New(config, mdriver.WithSchema("resume"))
+	if err != nil {
+		t.Fatalf("clickhouse connect %s", err)
+	}
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate("001.sql", strings.NewReader("SELECT throwIf(1, 'already run')")); err == nil || !strings.Contains(err.Error(), "altered") {
+		t.Errorf("expected 001.sql restored to history and reported altered, got %v", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	var scratch uint64
+	if err = db.QueryRow(`SELECT count() FROM system.tables WHERE database = 'resume' AND name = 'schema_migrations_upgrade'`).Scan(&scratch); err != nil {
+		t.Fatalf("select tables %s", err)
+	}
+	if scratch != 0 {
+		t.Error("expected scratch table dropped")
+	}
+}
+
+func TestClickHouseLoad(t *testing.T) {
+	migrator, err := driver.New(config)
+	if err != nil {
+		t.Skipf("clickhouse connect %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	schema := `CREATE TABLE IF NOT EXISTS default.load_test (code String, name String, population UInt64 DEFAULT 0) ENGINE = MergeTree() ORDER BY code`
+	if err = migrator.Migrate("load: schema", strings.NewReader(schema)); err != nil {
+		t.Fatalf("schema %s", err)
+	}
+
+	loader := migrator.(mdriver.Loader)
+	csv := "name,code,population\n\"New\nZealand\",NZ,5000000\n"
+	if err = loader.Load("load: csv", strings.NewReader(csv), mdriver.Data{Table: "default.load_test", Format: mdriver.CSV}); err != nil {
+		t.Fatalf("load csv %s", err)
+	}
+	ndjson := `{"code": "AU", "name": "Australia"}` + "\n" + `{"code": "FR", "name": "France", "population": 68000000}` + "\n"
+	if err = loader.Load("load: ndjson", strings.NewReader(ndjson), mdriver.Data{Table: "default.load_test", Format: mdriver.NDJSON}); err != nil {
+		t.Fatalf("load ndjson %s", err)
+	}
+
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("clickhouse", config)
+	if err != nil {
+		t.Fatalf("post migrate connect %s", err)
+	}
+	defer db.Close()
+
+	var got string
+	err = db.QueryRow(`SELECT arrayStringConcat(groupArray(code || ':' || toString(population)), ',') FROM (SELECT * FROM default.load_test ORDER BY code)`).Scan(&got)
+	if err != nil || got != "AU:0,FR:68000000,NZ:5000000" {
+		t.Errorf("expected loaded rows, got %q %v", got, err)
+	}
+}
@@ -0,0 +1,213 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
+)
+
+// DefaultDistributedDDLTimeout is how long ON CLUSTER DDL may take on every host.
+const DefaultDistributedDDLTimeout = 3 * time.Minute
+
+// ClusterDDL controls what happens to migration DDL without ON CLUSTER in
+// cluster mode.
+type ClusterDDL int
+
+const (
+	// ClusterDDLIgnore runs DDL as written.
+	ClusterDDLIgnore ClusterDDL = iota
+	// ClusterDDLValidate fails a migration with DDL missing ON CLUSTER.
+	ClusterDDLValidate
+	// ClusterDDLRewrite adds ON CLUSTER to DDL missing it.
+	ClusterDDLRewrite
+)
+
+// ParseClusterDDL parses ignore, validate or rewrite.
+func ParseClusterDDL(s string) (ClusterDDL, error) {
+	switch strings.ToLower(s) {
+	case "", "ignore":
+		return ClusterDDLIgnore, nil
+	case "validate":
+		return ClusterDDLValidate, nil
+	case "rewrite":
+		return ClusterDDLRewrite, nil
+	}
+	return 0, fmt.Errorf("unknown cluster ddl mode %q", s)
+}
+
+// WithCluster creates the migrate database, history and lock tables ON
+// CLUSTER with replicated engines so every replica shares one history, and
+// waits for distributed DDL to finish on every host before a migration is
+// recorded. Also set with the x-cluster DSN parameter.
+func WithCluster(cluster string) driver.Option {
+	return option(func(o *options) {
+		o.cluster = cluster
+	})
+}
+
+// WithClusterDDL sets how migration DDL missing ON CLUSTER is handled in
+// cluster mode. Also set with the x-cluster-ddl DSN parameter.
+func WithClusterDDL(mode ClusterDDL) driver.Option {
+	return option(func(o *options) {
+		o.clusterDDL = mode
+	})
+}
+
+// WithDistributedDDLTimeout sets how long ON CLUSTER DDL may take to finish on
+// every host before the migration fails.
+func WithDistributedDDLTimeout(timeout time.Duration) driver.Option {
+	return option(func(o *options) {
+		o.ddlTimeout = timeout
+	})
+}
+
+// dsnOptions strips migrate's own x- parameters from dsn, which clickhouse-go
+// would otherwise send to the server as settings, returning them as options.
+func dsnOptions(dsn string) (string, []driver.Option, error) {
+	dsn, values, err := driver.SplitDSNParams(dsn, "x-cluster", "x-cluster-ddl")
+	if err != nil || len(values) == 0 {
+		return dsn, nil, err
+	}
+	if err := driver.DSNParamNeeds(values, "x-cluster-ddl", "x-cluster"); err != nil {
+		return "", nil, err
+	}
+
+	var opts []driver.Option
+	if cluster := values.Get("x-cluster"); cluster != "" {
+		opts = append(opts, WithCluster(cluster))
+	}
+	if values.Has("x-cluster-ddl") {
+		mode, err := ParseClusterDDL(values.Get("x-cluster-ddl"))
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: x-cluster-ddl: %w", err)
+		}
+		opts = append(opts, WithClusterDDL(mode))
+	}
+	return dsn, opts, nil
+}
+
+var clusterNameRe = regexp.MustCompile(`^\w+$`)
+
+// onCluster returns the ON CLUSTER clause in cluster mode.
+func (c *ClickHouse) onCluster() string {
+	if c.cluster == "" {
+		return ""
+	}
+	if clusterNameRe.MatchString(c.cluster) {
+		return " ON CLUSTER " + c.cluster
+	}
+	return " ON CLUSTER '" + strings.ReplaceAll(c.cluster, "'", `\'`) + "'"
+}
+
+// engine returns a MergeTree family engine for table, replicated in cluster
+// mode. The ZooKeeper path names table explicitly rather than with the {table}
+// macro, which expands when the table is created and survives a rename, so a
+// replica added later always shares the path however the table came to have
+// its name.
+func (c *ClickHouse) engine(table, engine string, params ...string) string {
+	if c.cluster != "" {
+		engine = "Replicated" + engine
+		params = append([]string{`'/clickhouse/tables/{shard}/{database}/` + table + `'`, `'{replica}'`}, params...)
+	}
+	return engine + "(" + strings.Join(params, ", ") + ")"
+}
+
+// ddlContext applies distributed DDL settings in cluster mode so ON CLUSTER
+// statements block until every host has finished, or fail.
+func (c *ClickHouse) ddlContext(ctx context.Context) context.Context {
+	if c.cluster == "" {
+		return ctx
+	}
+	return clickhouse.Context(ctx, clickhouse.WithSettings(c.ddlSettings(clickhouse.Settings{})))
+}
+
+// statementContext applies the distributed DDL settings and any settings
+// given with WithSettings to a migration statement.
+func (c *ClickHouse) statementContext(ctx context.Context) context.Context {
+	if c.cluster == "" && len(c.settings) == 0 {
+		return ctx
+	}
+	settings := clickhouse.Settings{}
+	if c.cluster != "" {
+		c.ddlSettings(settings)
+	}
+	for k, v := range c.settings {
+		settings[k] = v
+	}
+	return clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+}
+
+func (c *ClickHouse) ddlSettings(settings clickhouse.Settings) clickhouse.Settings {
+	settings["distributed_ddl_task_timeout"] = int(c.ddlTimeout.Seconds())
+	settings["distributed_ddl_output_mode"] = "throw"
+	return settings
+}
+
+var (
+	ddlRe       = regexp.MustCompile(`(?is)^(?:create|alter|drop|rename|truncate|attach|detach|exchange)\b`)
+	onClusterRe = regexp.MustCompile(`(?is)\bon\s+cluster\b`)
+	objectRe    = regexp.MustCompile("(?is)^(?:create|alter|drop|truncate|attach|detach)\\s+(?:or\\s+replace\\s+)?(?:temporary\\s+)?(?:materialized\\s+|live\\s+|window\\s+)?(?:table|database|view|dictionary|function)\\s+(?:if\\s+(?:not\\s+)?exists\\s+)?(?:\\w+|`[^`]+`|\"[^\"]+\")(?:\\.(?:\\w+|`[^`]+`|\"[^\"]+\"))?")
+	renameRe    = regexp.MustCompile(`(?is)^(?:rename|exchange)\s+(?:table|tables|database|dictionary)\b`)
+)
+
+// clusterStatement validates or rewrites DDL missing ON CLUSTER.
+func (c *ClickHouse) clusterStatement(stmt statement.Statement) (string, error) {
+	if c.cluster == "" || c.clusterDDL == ClusterDDLIgnore {
+		return stmt.SQL, nil
+	}
+
+	start := statement.LeadingComments(stmt.SQL, statement.ClickHouse)
+	body := stmt.SQL[start:]
+	if !ddlRe.MatchString(body) || onClusterRe.MatchString(body) {
+		return stmt.SQL, nil
+	}
+
+	if c.clusterDDL == ClusterDDLValidate {
+		return "", fmt.Errorf("statement %d (line %d): DDL without ON CLUSTER %s", stmt.Index+1, stmt.Line, c.cluster)
+	}
+
+	switch {
+	case renameRe.MatchString(body):
+		return stmt.SQL + c.onCluster(), nil
+	case objectRe.MatchString(body):
+		end := start + objectRe.FindStringIndex(body)[1]
+		return stmt.SQL[:end] + c.onCluster() + stmt.SQL[end:], nil
+	}
+	return "", fmt.Errorf("statement %d (line %d): can't add ON CLUSTER, add it by hand", stmt.Index+1, stmt.Line)
+}
+
+// waitDistributedDDL waits for ON CLUSTER tasks queued since start, a server
+// time from serverTime, to finish on every host, so a migration isn't recorded
+// while replicas lag behind.
+func (c *ClickHouse) waitDistributedDDL(ctx context.Context, start time.Time) error {
+	if c.cluster == "" {
+		return nil
+	}
+
+	deadline := time.Now().Add(c.ddlTimeout)
+	for {
+		var pending uint64
+		err := c.db.QueryRowContext(ctx, `
+SELECT count()
+FROM system.distributed_ddl_queue
+WHERE cluster = ? AND query_create_time >= ? AND status != 'Finished'
+`, c.cluster, start.Truncate(time.Second)).Scan(&pending)
+		if err != nil {
+			return fmt.Errorf("distributed ddl queue: %w", err)
+		}
+		if pending == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("distributed ddl: %d tasks unfinished after %s", pending, c.ddlTimeout)
+		}
+		c.logger.Debug("migrate waiting for distributed ddl", "driver", "clickhouse", "cluster", c.cluster, "pending", pending)
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,106 @@
+package clickhouse
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	mdriver "github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
+)
+
+func TestClusterStatement(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    ClusterDDL
+		sql     string
+		want    string
+		wantErr bool
+	}{
+		{"ignore", ClusterDDLIgnore, "CREATE TABLE t (id UInt64) ENGINE = Memory", "CREATE TABLE t (id UInt64) ENGINE = Memory", false},
+		{"create", ClusterDDLRewrite, "CREATE TABLE IF NOT EXISTS db.t (id UInt64) ENGINE = Memory", "CREATE TABLE IF NOT EXISTS db.t ON CLUSTER prod (id UInt64) ENGINE = Memory", false},
+		{"comment", ClusterDDLRewrite, "-- users\nalter table `users` add column x UInt8", "-- users\nalter table `users` ON CLUSTER prod add column x UInt8", false},
+		{"view", ClusterDDLRewrite, "CREATE MATERIALIZED VIEW v TO t AS SELECT 1", "CREATE MATERIALIZED VIEW v ON CLUSTER prod TO t AS SELECT 1", false},
+		{"rename", ClusterDDLRewrite, "RENAME TABLE a TO b", "RENAME TABLE a TO b ON CLUSTER prod", false},
+		{"present", ClusterDDLRewrite, "DROP TABLE t ON CLUSTER prod", "DROP TABLE t ON CLUSTER prod", false},
+		{"insert", ClusterDDLValidate, "INSERT INTO t VALUES (1)", "INSERT INTO t VALUES (1)", false},
+		{"validate", ClusterDDLValidate, "DROP TABLE t", "", true},
+		{"unknown", ClusterDDLRewrite, "CREATE USER bob", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ClickHouse{cluster: "prod", clusterDDL: tt.mode}
+			got, err := c.clusterStatement(statement.Split(tt.sql, statement.ClickHouse)[0])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cluster statement error %v, want error %t", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("cluster statement mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDSNOptions(t *testing.T) {
+	dsn, opts, err := dsnOptions("clickhouse://localhost:9000/default?dial_timeout=1s&x-cluster=prod&x-cluster-ddl=validate")
+	if err != nil {
+		t.Fatalf("dsn options %s", err)
+	}
+	if diff := cmp.Diff("clickhouse://localhost:9000/default?dial_timeout=1s", dsn); diff != "" {
+		t.Errorf("dsn mismatch (-want +got):\n%s", diff)
+	}
+
+	c := newClickHouse(nil, opts)
+	if c.cluster != "prod" || c.clusterDDL != ClusterDDLValidate {
+		t.Errorf("options cluster %q mode %d", c.cluster, c.clusterDDL)
+	}
+
+	if _, _, err := dsnOptions("clickhouse://localhost?x-cluster-ddl=bogus"); err == nil {
+		t.Error("expected error for unknown cluster ddl mode")
+	}
+	if _, _, err := dsnOptions("clickhouse://localhost?x-cluster-ddl=validate"); err == nil {
+		t.Error("expected error for cluster ddl without cluster")
+	}
+}
+
+func TestUpgradeSteps(t *testing.T) {
+	c := newClickHouse(nil, []mdriver.Option{WithCluster("prod")})
+
+	paths := map[string]string{}
+	for _, step := range c.upgradeSteps(false) {
+		if strings.Contains(step.sql, "RENAME") {
+			t.Errorf("step %s renames a replicated table:\n%s", step.name, step.sql)
+		}
+		if strings.Contains(step.sql, "{table}") {
+			t.Errorf("step %s uses the {table} macro:\n%s", step.name, step.sql)
+		}
+		if match := zooKeeperPathRe.FindStringSubmatch(step.sql); match != nil {
+			paths[step.name] = match[1]
+		}
+	}
+
+	want := map[string]string{
+		"create scratch": "/clickhouse/tables/{shard}/{database}/schema_migrations_upgrade",
+		"create":         "/clickhouse/tables/{shard}/{database}/schema_migrations",
+	}
+	if diff := cmp.Diff(want, paths); diff != "" {
+		t.Errorf("zookeeper paths mismatch (-want +got):\n%s", diff)
+	}
+
+	// A fresh replica gets the same path as the upgraded table.
+	if match := zooKeeperPathRe.FindStringSubmatch(c.createTableSQL()); match == nil || match[1] != want["create"] {
+		t.Errorf("expected history table at %s, got %v", want["create"], match)
+	}
+
+	var resumed []string
+	for _, step := range c.upgradeSteps(true) {
+		resumed = append(resumed, step.name)
+	}
+	if diff := cmp.Diff([]string{"restore", "drop scratch"}, resumed); diff != "" {
+		t.Errorf("resumed steps mismatch (-want +got):\n%s", diff)
+	}
+}
+
+var zooKeeperPathRe = regexp.MustCompile(`Replicated\w+\('([^']+)'`)
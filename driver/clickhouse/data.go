@@ -0,0 +1,103 @@
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/shanna/migrate/driver"
+)
+
+// loadChunk is roughly how many bytes of a data file are sent per insert.
+const loadChunk = 1 << 20
+
+// Load a data file into an existing table with INSERT ... SELECT FROM format()
+// in chunks of whole rows, matching columns by name. CSV needs a header row.
+// Parquet isn't supported.
+//
+// Like any other migration a failure part way through leaves the load dirty
+// with the chunks already inserted in place.
+func (c *ClickHouse) Load(name string, data io.Reader, load driver.Data) error {
+	ctx := context.Background()
+
+	var format string
+	switch load.Format {
+	case driver.CSV:
+		format = "CSVWithNames"
+	case driver.NDJSON:
+		format = "JSONEachRow"
+	default:
+		return fmt.Errorf("load %s: %s: %w", name, load.Format, driver.ErrUnsupported)
+	}
+
+	stream := driver.NewDataStream(data)
+
+	skip, err := c.skip(ctx, name, stream.Checksum)
+	if err != nil || skip {
+		return err
+	}
+	if err := c.holding(ctx); err != nil {
+		return err
+	}
+
+	if err := c.dirtyRow(ctx, name); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := c.load(ctx, stream, load, format); err != nil {
+		c.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "clickhouse", "error", err, "table", load.Table, "format", load.Format.String())
+		return err
+	}
+
+	return c.record(ctx, name, stream.Checksum, start)
+}
+
+func (c *ClickHouse) load(ctx context.Context, r io.Reader, load driver.Data, format string) error {
+	settings := clickhouse.Settings{"max_query_size": 4 * loadChunk}
+	for k, v := range c.settings {
+		settings[k] = v
+	}
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+	query := fmt.Sprintf(`INSERT INTO %s SELECT * FROM format(%s, ?)`, load.Table, format)
+
+	reader := bufio.NewReader(r)
+
+	// Every CSV chunk repeats the header row.
+	var header []byte
+	if load.Format == driver.CSV {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("csv header: %w", err)
+		}
+		header = line
+	}
+
+	var chunk bytes.Buffer
+	quotes := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("read: %w", err)
+		}
+		chunk.Write(line)
+		quotes += bytes.Count(line, []byte{'"'})
+
+		// Only split between rows, never inside a quoted CSV field holding
+		// a newline.
+		whole := load.Format != driver.CSV || quotes%2 == 0
+		if chunk.Len() > 0 && (err == io.EOF || whole && chunk.Len() >= loadChunk) {
+			if _, err := c.db.ExecContext(ctx, query, string(header)+chunk.String()); err != nil {
+				return err
+			}
+			chunk.Reset()
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
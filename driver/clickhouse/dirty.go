@@ -0,0 +1,117 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
+)
+
+// ErrDirty is returned by Begin while an earlier migration is dirty.
+var ErrDirty = driver.ErrDirty
+
+// selectDirtySQL returns the first migration whose latest row is dirty.
+func (c *ClickHouse) selectDirtySQL() string {
+	return fmt.Sprintf(`
+SELECT name, completed
+FROM %s FINAL
+WHERE dirty = 1
+ORDER BY name
+LIMIT 1
+`, c.qualifiedTableName())
+}
+
+func (c *ClickHouse) clearMigrationSQL() string {
+	return fmt.Sprintf(`ALTER TABLE %s DELETE WHERE name = ? SETTINGS mutations_sync = 2`, c.qualifiedTableName())
+}
+
+// dirty returns the first dirty migration if there is one.
+func (c *ClickHouse) dirty(ctx context.Context) error {
+	var e DirtyError
+	err := c.db.QueryRowContext(ctx, c.selectDirtySQL()).Scan(&e.Name, &e.Started)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("schema_migrations select dirty: %w", err)
+	}
+	return &e
+}
+
+// DirtyError names the migration left dirty.
+type DirtyError = driver.DirtyError
+
+// Resolve marks the dirty migration name completed after it has been repaired
+// by hand. Data is the migration so its checksum can be recorded.
+func (c *ClickHouse) Resolve(name string, data io.Reader) error {
+	return c.resolve(name, func(ctx context.Context) error {
+		checksum, err := driver.NewStream(data, statement.ClickHouse).Checksum()
+		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		if _, err := c.db.ExecContext(ctx, c.insertMigrationSQL(), name, base64.StdEncoding.EncodeToString(checksum), 0, c.host, 0); err != nil {
+			return fmt.Errorf("schema_migrations insert: %w", err)
+		}
+		c.logger.Info(fmt.Sprintf("migrate resolved %s", name), "driver", "clickhouse")
+		return nil
+	})
+}
+
+// Clear forgets the dirty migration name so the next run executes it again.
+// Undo whatever part of it did run first.
+func (c *ClickHouse) Clear(name string) error {
+	return c.resolve(name, func(ctx context.Context) error {
+		if _, err := c.db.ExecContext(ctx, c.clearMigrationSQL(), name); err != nil {
+			return fmt.Errorf("schema_migrations delete: %w", err)
+		}
+		c.logger.Info(fmt.Sprintf("migrate cleared %s", name), "driver", "clickhouse")
+		return nil
+	})
+}
+
+// resolve runs fn holding the migration lock once name is known to be dirty.
+func (c *ClickHouse) resolve(name string, fn func(ctx context.Context) error) error {
+	ctx := context.Background()
+
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	if err := c.setup(ctx); err != nil {
+		return err
+	}
+	if err := c.lock(ctx); err != nil {
+		return err
+	}
+	defer c.release(ctx)
+
+	if err := c.upgrade(ctx); err != nil {
+		return err
+	}
+
+	previous, err := c.previous(ctx, name)
+	if err != nil {
+		return err
+	}
+	if previous == nil || !previous.dirty {
+		return fmt.Errorf("%q: %w", name, driver.ErrNotDirty)
+	}
+	return fn(ctx)
+}
+
+// previous returns the latest history row for name or nil if it never ran.
+func (c *ClickHouse) previous(ctx context.Context, name string) (*migrate, error) {
+	previous := migrate{}
+	err := c.db.QueryRowContext(ctx, c.selectMigrationSQL(), name).Scan(&previous.name, &previous.completed, &previous.checksum, &previous.dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("schema_migrations select previous: %w", err)
+	}
+	return &previous, nil
+}
@@ -0,0 +1,89 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// upgradeStep is a single statement of a history table upgrade.
+type upgradeStep struct {
+	name string
+	sql  string
+}
+
+// upgrade converts a history table created by an earlier version of the
+// driver, a plain MergeTree that kept duplicate rows, into the current
+// ReplacingMergeTree. ClickHouse can't change a table's engine in place, and
+// renaming a replicated table keeps its ZooKeeper path, so the history is
+// copied out to a scratch table, the old table dropped and recreated under its
+// own path, then the history copied back. An upgrade that crashed after
+// dropping the old table is finished from the scratch table by the next run.
+// Run holding the migration lock.
+func (c *ClickHouse) upgrade(ctx context.Context) error {
+	var engine string
+	err := c.db.QueryRowContext(ctx, `SELECT engine FROM system.tables WHERE database = ? AND name = ?`, c.database, c.tableName).Scan(&engine)
+	if err != nil {
+		return fmt.Errorf("upgrade engine: %w", err)
+	}
+
+	var scratch uint64
+	err = c.db.QueryRowContext(ctx, `SELECT count() FROM system.tables WHERE database = ? AND name = ?`, c.database, c.upgradeTableName()).Scan(&scratch)
+	if err != nil {
+		return fmt.Errorf("upgrade scratch table: %w", err)
+	}
+
+	upgraded := strings.HasSuffix(engine, "ReplacingMergeTree")
+	if upgraded && scratch == 0 {
+		return nil
+	}
+
+	c.logger.Info("migrate upgrading history table", "driver", "clickhouse", "table", c.qualifiedTableName(), "engine", engine, "resume", upgraded)
+
+	ctx = c.ddlContext(ctx)
+	for _, step := range c.upgradeSteps(upgraded) {
+		if _, err := c.db.ExecContext(ctx, step.sql); err != nil {
+			return fmt.Errorf("upgrade %s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+func (c *ClickHouse) upgradeTableName() string {
+	return c.tableName + "_upgrade"
+}
+
+// upgradeSteps returns the statements upgrading the history table, or only
+// those copying the history back from the scratch table when resume is set.
+func (c *ClickHouse) upgradeSteps(resume bool) []upgradeStep {
+	table := c.qualifiedTableName()
+	scratch := c.database + "." + c.upgradeTableName()
+
+	finish := []upgradeStep{
+		{"restore", fmt.Sprintf(`
+INSERT INTO %s (name, checksum, completed, dirty, host, duration_ms, version)
+SELECT name, checksum, completed, dirty, host, duration_ms, version FROM %s
+`, table, scratch)},
+		{"drop scratch", fmt.Sprintf(`DROP TABLE %s%s SYNC`, scratch, c.onCluster())},
+	}
+	if resume {
+		return finish
+	}
+
+	return append([]upgradeStep{
+		// Tables from before dirty tracking lack columns the copy selects.
+		{"columns", fmt.Sprintf(`ALTER TABLE %s%s
+  ADD COLUMN IF NOT EXISTS dirty UInt8 DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS version UInt64 DEFAULT 0`, table, c.onCluster())},
+		// Left behind by an upgrade that crashed before dropping the old table.
+		{"drop stale scratch", fmt.Sprintf(`DROP TABLE IF EXISTS %s%s SYNC`, scratch, c.onCluster())},
+		{"create scratch", c.createTableNameSQL(c.upgradeTableName())},
+		{"copy", fmt.Sprintf(`
+INSERT INTO %s (name, checksum, completed, dirty, version)
+SELECT name, checksum, completed, dirty, version FROM %s
+`, scratch, table)},
+		// SYNC frees the replicated table's ZooKeeper path for the new one.
+		{"drop previous", fmt.Sprintf(`DROP TABLE %s%s SYNC`, table, c.onCluster())},
+		{"create", c.createTableSQL()},
+	}, finish...)
+}
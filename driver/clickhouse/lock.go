@@ -0,0 +1,276 @@
+package clickhouse
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shanna/migrate/driver"
+)
+
+const (
+	// lockPoll is how often a held lock is checked while waiting.
+	lockPoll = time.Second
+	// lockSettle is how long a claim is left before reading back the winner.
+	// A claim is only inserted while the lock is free, so two runs can only
+	// race when both find it free within moments of each other; whichever
+	// insert ReplacingMergeTree keeps wins and the other backs off.
+	lockSettle = 500 * time.Millisecond
+)
+
+// ErrLocked is returned by Begin when another run holds the migration lock.
+var ErrLocked = driver.ErrLocked
+
+// LockError describes the run holding the migration lock.
+type LockError struct {
+	driver.LockInfo
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("%s (owner %s on %s, since %s, lease expires %s)",
+		ErrLocked, e.Owner, e.Host, e.Acquired.Format(time.RFC3339), e.Expires.Format(time.RFC3339))
+}
+
+func (e *LockError) Unwrap() error {
+	return ErrLocked
+}
+
+func (c *ClickHouse) qualifiedLockTableName() string {
+	return c.qualifiedTableName() + "_lock"
+}
+
+// createLockTableSQL holds a single row per lock. Every claim, heartbeat and
+// release is an insert with a newer server generated version, reads use FINAL
+// so only the latest survives.
+func (c *ClickHouse) createLockTableSQL() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s%s (
+  name String,
+  owner String,
+  host String,
+  acquired DateTime64(3),
+  expires DateTime64(3),
+  version UInt64
+) ENGINE = %s
+ORDER BY name`, c.qualifiedLockTableName(), c.onCluster(), c.engine(c.tableName+"_lock", "ReplacingMergeTree", "version"))
+}
+
+func (c *ClickHouse) selectLockSQL() string {
+	return fmt.Sprintf(`
+SELECT owner, host, acquired, expires, now64(3)
+FROM %s FINAL
+WHERE name = ?
+`, c.qualifiedLockTableName())
+}
+
+// claimLockSQL inserts a claim unless the lock is held. Times come from the
+// server so clock skew between hosts doesn't matter.
+func (c *ClickHouse) claimLockSQL() string {
+	return fmt.Sprintf(`
+INSERT INTO %s (name, owner, host, acquired, expires, version)
+SELECT ?, ?, ?, now64(3), now64(3) + toIntervalMillisecond(?), toUnixTimestamp64Nano(now64(9))
+WHERE (SELECT count() FROM %s FINAL WHERE name = ? AND owner != '' AND expires > now64(3)) = 0
+`, c.qualifiedLockTableName(), c.qualifiedLockTableName())
+}
+
+// extendLockSQL inserts a heartbeat only while this run still holds the lock.
+func (c *ClickHouse) extendLockSQL() string {
+	return fmt.Sprintf(`
+INSERT INTO %s (name, owner, host, acquired, expires, version)
+SELECT ?, ?, ?, ?, now64(3) + toIntervalMillisecond(?), toUnixTimestamp64Nano(now64(9))
+WHERE (SELECT count() FROM %s FINAL WHERE name = ? AND owner = ? AND expires > now64(3)) = 1
+`, c.qualifiedLockTableName(), c.qualifiedLockTableName())
+}
+
+func (c *ClickHouse) releaseLockSQL() string {
+	return fmt.Sprintf(`
+INSERT INTO %s (name, owner, host, acquired, expires, version)
+SELECT ?, '', '', now64(3), now64(3), toUnixTimestamp64Nano(now64(9))
+`, c.qualifiedLockTableName())
+}
+
+// lockOwner identifies this run.
+func lockOwner() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return fmt.Sprintf("%d-%s", os.Getpid(), hex.EncodeToString(id))
+}
+
+// lockInfo reads the current holder and the server's clock.
+func (c *ClickHouse) lockInfo(ctx context.Context) (driver.LockInfo, time.Time, error) {
+	var (
+		info driver.LockInfo
+		now  time.Time
+	)
+	err := c.db.QueryRowContext(ctx, c.selectLockSQL(), c.tableName).Scan(&info.Owner, &info.Host, &info.Acquired, &info.Expires, &now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return driver.LockInfo{}, now, nil
+	}
+	return info, now, err
+}
+
+// lockTableExists reports whether the lock table has been created, so the lock
+// can be inspected without creating anything.
+func (c *ClickHouse) lockTableExists(ctx context.Context) (bool, error) {
+	var count uint64
+	err := c.db.QueryRowContext(ctx, `SELECT count() FROM system.tables WHERE database = ? AND name = ?`, c.database, c.tableName+"_lock").Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("lock table: %w", err)
+	}
+	return count > 0, nil
+}
+
+// lock claims the migration lock, waiting up to c.lockWait for another run to
+// release it or for its lease to expire, then keeps it alive with heartbeats
+// until unlock.
+func (c *ClickHouse) lock(ctx context.Context) error {
+	deadline := time.Now().Add(c.lockWait)
+
+	for {
+		info, now, err := c.lockInfo(ctx)
+		if err != nil {
+			return fmt.Errorf("lock select: %w", err)
+		}
+
+		if !info.Held(now) {
+			if info.Owner != "" {
+				c.logger.Info("migrate taking over expired lock", "driver", "clickhouse", "owner", info.Owner, "host", info.Host, "expired", info.Expires)
+			}
+			if _, err := c.db.ExecContext(ctx, c.claimLockSQL(), c.tableName, c.owner, c.host, c.lease.Milliseconds(), c.tableName); err != nil {
+				return fmt.Errorf("lock claim: %w", err)
+			}
+
+			time.Sleep(lockSettle)
+			if info, _, err = c.lockInfo(ctx); err != nil {
+				return fmt.Errorf("lock select: %w", err)
+			}
+			if info.Owner == c.owner {
+				c.lost.Store(nil)
+				c.heartbeat(info.Acquired)
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return &LockError{info}
+		}
+		c.logger.Debug("migrate waiting for lock", "driver", "clickhouse", "owner", info.Owner, "host", info.Host)
+		time.Sleep(lockPoll)
+	}
+}
+
+// heartbeat extends the lease every third of its length until release. Once
+// the lease has lapsed or another run has taken the lock over the run is
+// marked as having lost it and the heartbeat stops.
+func (c *ClickHouse) heartbeat(acquired time.Time) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	c.stopHeartbeat = func() {
+		close(stop)
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(c.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			info, now, err := c.lockInfo(context.Background())
+			if err != nil {
+				c.logger.Error("migrate lock heartbeat", "driver", "clickhouse", "error", err)
+				continue
+			}
+			if info.Owner != c.owner || !info.Held(now) {
+				c.logger.Error("migrate lock lost", "driver", "clickhouse", "owner", info.Owner, "host", info.Host, "expires", info.Expires)
+				c.lost.Store(&info)
+				return
+			}
+			if _, err := c.db.Exec(c.extendLockSQL(), c.tableName, c.owner, c.host, acquired, c.lease.Milliseconds(), c.tableName, c.owner); err != nil {
+				c.logger.Error("migrate lock heartbeat", "driver", "clickhouse", "error", err)
+			}
+		}
+	}()
+}
+
+// holding fails once this run no longer holds the migration lock, so a run
+// whose lease lapsed never migrates alongside the run that took it over.
+func (c *ClickHouse) holding(ctx context.Context) error {
+	if info := c.lost.Load(); info != nil {
+		return fmt.Errorf("migration lock lost: %w", &LockError{*info})
+	}
+
+	info, now, err := c.lockInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("lock select: %w", err)
+	}
+	if info.Owner != c.owner || !info.Held(now) {
+		c.lost.Store(&info)
+		return fmt.Errorf("migration lock lost: %w", &LockError{info})
+	}
+	return nil
+}
+
+// release the lock if this run still holds it.
+func (c *ClickHouse) release(ctx context.Context) error {
+	if c.stopHeartbeat == nil {
+		return nil
+	}
+	c.stopHeartbeat()
+	c.stopHeartbeat = nil
+
+	info, _, err := c.lockInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("lock select: %w", err)
+	}
+	if info.Owner != c.owner {
+		c.logger.Error("migrate lock was taken by another run", "driver", "clickhouse", "owner", info.Owner, "host", info.Host)
+		return nil
+	}
+	if _, err := c.db.ExecContext(ctx, c.releaseLockSQL(), c.tableName); err != nil {
+		return fmt.Errorf("lock release: %w", err)
+	}
+	return nil
+}
+
+// LockInfo returns the current holder of the migration lock. The zero
+// LockInfo means nobody has taken it yet.
+func (c *ClickHouse) LockInfo() (driver.LockInfo, error) {
+	ctx := context.Background()
+	exists, err := c.lockTableExists(ctx)
+	if err != nil || !exists {
+		return driver.LockInfo{}, err
+	}
+	info, _, err := c.lockInfo(ctx)
+	return info, err
+}
+
+// BreakLock releases the migration lock whoever holds it. Only use it once the
+// holder is known to be dead; a live holder carries on unaware.
+func (c *ClickHouse) BreakLock() error {
+	ctx := context.Background()
+	exists, err := c.lockTableExists(ctx)
+	if err != nil || !exists {
+		return err
+	}
+	if _, err := c.db.ExecContext(ctx, c.releaseLockSQL(), c.tableName); err != nil {
+		return fmt.Errorf("lock release: %w", err)
+	}
+	return nil
+}
+
+// Close the database if the driver owns it, for use after LockInfo, BreakLock,
+// Resolve or Clear outside of a migration run.
+func (c *ClickHouse) Close() error {
+	c.close()
+	return nil
+}
@@ -0,0 +1,142 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
+)
+
+// DefaultMutationTimeout is how long a migration waits for its mutations.
+const DefaultMutationTimeout = 30 * time.Minute
+
+// mutationPoll is how often system.mutations is checked while waiting.
+const mutationPoll = time.Second
+
+// WithMutationTimeout sets how long a migration waits for the mutations it
+// created, such as ALTER TABLE ... UPDATE or DELETE, to finish before it fails.
+// Zero records the migration without waiting.
+func WithMutationTimeout(timeout time.Duration) driver.Option {
+	return option(func(o *options) {
+		o.mutationTimeout = timeout
+	})
+}
+
+// WithSettings sets ClickHouse query settings, such as mutations_sync, on
+// every migration statement.
+func WithSettings(settings map[string]any) driver.Option {
+	return option(func(o *options) {
+		o.settings = settings
+	})
+}
+
+// mutationTableRe matches statements that may create a mutation.
+var mutationTableRe = regexp.MustCompile("(?is)^(?:alter\\s+table\\s+(?:if\\s+exists\\s+)?|delete\\s+from\\s+|update\\s+)((?:\\w+|`[^`]+`)(?:\\.(?:\\w+|`[^`]+`))?)")
+
+// mutationTable returns the database and table a statement may mutate, or an
+// empty table if it can't create a mutation. An unqualified table leaves the
+// database empty for the server's current database.
+func mutationTable(sql string) (database, table string) {
+	match := mutationTableRe.FindStringSubmatch(sql[statement.LeadingComments(sql, statement.ClickHouse):])
+	if match == nil {
+		return "", ""
+	}
+	name := match[1]
+	if i := qualifierEnd(name); i > 0 {
+		database, name = name[:i], name[i+1:]
+	}
+	return strings.Trim(database, "`"), strings.Trim(name, "`")
+}
+
+// qualifierEnd returns the index of the dot after a database name or -1.
+func qualifierEnd(name string) int {
+	if strings.HasPrefix(name, "`") {
+		end := strings.IndexByte(name[1:], '`') + 2
+		if end < len(name) && name[end] == '.' {
+			return end
+		}
+		return -1
+	}
+	return strings.IndexByte(name, '.')
+}
+
+type mutation struct {
+	id         string
+	command    string
+	done       bool
+	failReason string
+}
+
+func (c *ClickHouse) selectMutationsSQL() string {
+	return `
+SELECT mutation_id, command, is_done, latest_fail_reason
+FROM system.mutations
+WHERE database = if(? = '', currentDatabase(), ?) AND table = ? AND create_time >= ?
+ORDER BY create_time
+`
+}
+
+// serverTime returns the server's clock so mutation create times can be
+// compared without clock skew.
+func (c *ClickHouse) serverTime(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	if err := c.db.QueryRowContext(ctx, `SELECT now()`).Scan(&now); err != nil {
+		return now, fmt.Errorf("server time: %w", err)
+	}
+	return now, nil
+}
+
+// waitMutations waits for mutations created on database.table since start to
+// finish. A mutation that keeps failing fails the migration with ClickHouse's
+// reason rather than leaving it to retry forever in the background.
+func (c *ClickHouse) waitMutations(ctx context.Context, database, table string, start time.Time) error {
+	deadline := time.Now().Add(c.mutationTimeout)
+	for {
+		mutations, err := c.mutations(ctx, database, table, start)
+		if err != nil {
+			return err
+		}
+
+		var pending []string
+		for _, m := range mutations {
+			if m.done {
+				continue
+			}
+			if m.failReason != "" {
+				return fmt.Errorf("mutation %s (%s) on %s failed: %s", m.id, m.command, table, m.failReason)
+			}
+			pending = append(pending, m.id)
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("mutations %s on %s unfinished after %s", strings.Join(pending, ", "), table, c.mutationTimeout)
+		}
+		c.logger.Debug("migrate waiting for mutations", "driver", "clickhouse", "table", table, "mutations", pending)
+		time.Sleep(mutationPoll)
+	}
+}
+
+func (c *ClickHouse) mutations(ctx context.Context, database, table string, start time.Time) ([]mutation, error) {
+	rows, err := c.db.QueryContext(ctx, c.selectMutationsSQL(), database, database, table, start)
+	if err != nil {
+		return nil, fmt.Errorf("mutations select: %w", err)
+	}
+	defer rows.Close()
+
+	var mutations []mutation
+	for rows.Next() {
+		var m mutation
+		if err := rows.Scan(&m.id, &m.command, &m.done, &m.failReason); err != nil {
+			return nil, fmt.Errorf("mutations scan: %w", err)
+		}
+		mutations = append(mutations, m)
+	}
+	return mutations, rows.Err()
+}
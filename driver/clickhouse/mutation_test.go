@@ -0,0 +1,30 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMutationTable(t *testing.T) {
+	tests := []struct {
+		sql      string
+		database string
+		table    string
+	}{
+		{"ALTER TABLE events UPDATE x = 1 WHERE 1", "", "events"},
+		{"-- backfill\nalter table if exists db.events delete where x = 0", "db", "events"},
+		{"ALTER TABLE `my db`.`my.events` MATERIALIZE COLUMN x", "my db", "my.events"},
+		{"DELETE FROM db.events WHERE x = 0", "db", "events"},
+		{"UPDATE events SET x = 1 WHERE 1", "", "events"},
+		{"CREATE TABLE events (x UInt8) ENGINE = Memory", "", ""},
+		{"INSERT INTO events VALUES (1)", "", ""},
+	}
+
+	for _, tt := range tests {
+		database, table := mutationTable(tt.sql)
+		if diff := cmp.Diff([]string{tt.database, tt.table}, []string{database, table}); diff != "" {
+			t.Errorf("mutation table %q mismatch (-want +got):\n%s", tt.sql, diff)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package clickhouse
+
+import (
+	"time"
+
+	"github.com/shanna/migrate/driver"
+)
+
+const (
+	// DefaultLease is how long a lock is held without a heartbeat.
+	DefaultLease = time.Minute
+	// DefaultLockWait is how long Begin waits for another run to finish.
+	DefaultLockWait = 10 * time.Minute
+)
+
+// options specific to the ClickHouse driver.
+type options struct {
+	lease      time.Duration
+	lockWait   time.Duration
+	cluster    string
+	clusterDDL ClusterDDL
+	ddlTimeout time.Duration
+
+	mutationTimeout time.Duration
+	settings        map[string]any
+}
+
+func defaultOptions() *options {
+	return &options{
+		lease:      DefaultLease,
+		lockWait:   DefaultLockWait,
+		ddlTimeout: DefaultDistributedDDLTimeout,
+
+		mutationTimeout: DefaultMutationTimeout,
+	}
+}
+
+type optionsKey struct{}
+
+// option returns a driver.Option updating the ClickHouse specific options.
+func option(f func(*options)) driver.Option {
+	return func(c *driver.Config) {
+		o, _ := c.Value(optionsKey{}).(*options)
+		if o == nil {
+			o = defaultOptions()
+			c.SetValue(optionsKey{}, o)
+		}
+		f(o)
+	}
+}
+
+// configOptions returns the ClickHouse specific options set on config.
+func configOptions(config *driver.Config) options {
+	if o, ok := config.Value(optionsKey{}).(*options); ok {
+		return *o
+	}
+	return *defaultOptions()
+}
+
+// WithLease sets how long the migration lock survives without a heartbeat.
+// A run that crashes holds the lock for at most this long before another run
+// may take it over.
+func WithLease(lease time.Duration) driver.Option {
+	return option(func(o *options) {
+		o.lease = lease
+	})
+}
+
+// WithLockWait sets how long Begin waits for another run to release the
+// migration lock; zero fails straight away.
+func WithLockWait(wait time.Duration) driver.Option {
+	return option(func(o *options) {
+		o.lockWait = wait
+	})
+}
@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DataFormat of a data file migration.
+type DataFormat int
+
+const (
+	// CSV with a header row naming the columns.
+	CSV DataFormat = iota + 1
+	// NDJSON holds one JSON object per line keyed by column.
+	NDJSON
+	// Parquet files carry their own column names and types.
+	Parquet
+)
+
+func (f DataFormat) String() string {
+	switch f {
+	case CSV:
+		return "csv"
+	case NDJSON:
+		return "ndjson"
+	case Parquet:
+		return "parquet"
+	}
+	return fmt.Sprintf("format(%d)", int(f))
+}
+
+var dataFormats = map[string]DataFormat{
+	".csv":     CSV,
+	".ndjson":  NDJSON,
+	".jsonl":   NDJSON,
+	".parquet": Parquet,
+}
+
+// DataSidecar is the extension of a file naming the table a data file of the
+// same name loads into, e.g. 010-countries.csv.table holding geo.countries.
+const DataSidecar = ".table"
+
+// Data describes a data file migration that loads rows into an existing table.
+type Data struct {
+	Table  string
+	Format DataFormat
+}
+
+// Loader is implemented by drivers that can load data file migrations. Loads
+// are checksummed and recorded in the history table like any other migration.
+type Loader interface {
+	Load(name string, data io.Reader, load Data) error
+}
+
+var (
+	dataPrefixRe = regexp.MustCompile(`^\d+[-_.]?`)
+	dataTableRe  = regexp.MustCompile(`^\w+(\.\w+){0,2}$`)
+)
+
+// DataFile reports whether file is a data file migration by its extension.
+// The table is named by a sidecar file when there is one, otherwise by the
+// file name without its extension or leading number, so 010-countries.csv
+// loads into countries.
+func DataFile(fsys fs.FS, file string) (Data, bool, error) {
+	format, ok := DataFormatOf(file)
+	if !ok {
+		return Data{}, false, nil
+	}
+
+	table := dataPrefixRe.ReplaceAllString(strings.TrimSuffix(path.Base(file), path.Ext(file)), "")
+	sidecar, err := fs.ReadFile(fsys, file+DataSidecar)
+	switch {
+	case err == nil:
+		table = strings.TrimSpace(string(sidecar))
+	case !errors.Is(err, fs.ErrNotExist):
+		return Data{}, false, fmt.Errorf("read %s: %w", file+DataSidecar, err)
+	}
+
+	if !dataTableRe.MatchString(table) {
+		return Data{}, false, fmt.Errorf("data %s: invalid table name %q", file, table)
+	}
+	return Data{Table: table, Format: format}, true, nil
+}
+
+// DataFormatOf returns the format of a data file by its extension.
+func DataFormatOf(file string) (DataFormat, bool) {
+	format, ok := dataFormats[strings.ToLower(path.Ext(file))]
+	return format, ok
+}
+
+// IsDataSidecar reports whether file names the table for a data file rather
+// than being a migration itself.
+func IsDataSidecar(file string) bool {
+	data, ok := strings.CutSuffix(file, DataSidecar)
+	if !ok {
+		return false
+	}
+	_, ok = DataFormatOf(data)
+	return ok
+}
+
+// DataStream hashes a data file as it is read so it can be checksummed like
+// any other migration.
+type DataStream struct {
+	io.Reader
+	checksum hash.Hash
+}
+
+// NewDataStream reads a data file migration from data.
+func NewDataStream(data io.Reader) *DataStream {
+	checksum := sha512.New()
+	return &DataStream{Reader: io.TeeReader(data, checksum), checksum: checksum}
+}
+
+// Checksum drains anything left unread and returns the SHA-512 of the whole
+// file.
+func (s *DataStream) Checksum() ([]byte, error) {
+	if _, err := io.Copy(io.Discard, s.Reader); err != nil {
+		return nil, err
+	}
+	return s.checksum.Sum(nil), nil
+}
@@ -0,0 +1,51 @@
+package driver_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shanna/migrate/driver"
+)
+
+func TestDataFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"020-regions.csv.table": {Data: []byte(" geo.regions\n")},
+		"030-bad.csv.table":     {Data: []byte("drop table users; --")},
+	}
+
+	var tests = []struct {
+		file string
+		want driver.Data
+		ok   bool
+		err  bool
+	}{
+		{`010-countries.csv`, driver.Data{Table: "countries", Format: driver.CSV}, true, false},
+		{`010_feature_flags.NDJSON`, driver.Data{Table: "feature_flags", Format: driver.NDJSON}, true, false},
+		{`events.jsonl`, driver.Data{Table: "events", Format: driver.NDJSON}, true, false},
+		{`011.sales.parquet`, driver.Data{Table: "sales", Format: driver.Parquet}, true, false},
+		{`020-regions.csv`, driver.Data{Table: "geo.regions", Format: driver.CSV}, true, false},
+		{`030-bad.csv`, driver.Data{}, false, true},
+		{`040-two words.csv`, driver.Data{}, false, true},
+		{`001-schema.sql`, driver.Data{}, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			data, ok, err := driver.DataFile(fsys, test.file)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %t, got %v", test.err, err)
+			}
+			if ok != test.ok {
+				t.Errorf("expected ok %t, got %t", test.ok, ok)
+			}
+			if diff := cmp.Diff(test.want, data); diff != "" {
+				t.Errorf("data mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if !driver.IsDataSidecar("020-regions.csv.table") || driver.IsDataSidecar("users.table") {
+		t.Errorf("sidecar detection mismatch")
+	}
+}
@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrDirty is returned when an earlier migration failed part way through on a
+// database without transactional DDL and has to be repaired by hand.
+var ErrDirty = errors.New("dirty migration")
+
+// ErrNotDirty is returned when resolving a migration that isn't dirty.
+var ErrNotDirty = errors.New("migration is not dirty")
+
+// DirtyError names the migration left dirty.
+type DirtyError struct {
+	Name    string
+	Started time.Time
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("%s %q started %s and never completed; repair the database then run migrate resolve", ErrDirty, e.Name, e.Started.Format(time.RFC3339))
+}
+
+func (e *DirtyError) Unwrap() error {
+	return ErrDirty
+}
+
+// Resolver is implemented by drivers that record a dirty row before running a
+// migration so a partial failure can be resolved once repaired by hand.
+type Resolver interface {
+	// Resolve marks the dirty migration name completed with the checksum of data.
+	Resolve(name string, data io.Reader) error
+	// Clear forgets the dirty migration name so the next run executes it again.
+	Clear(name string) error
+}
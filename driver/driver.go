@@ -25,6 +25,21 @@ type Config struct {
 	TableName string
 	Logger    Logger
 	NameFunc  func(string) string
+	values    map[any]any
+}
+
+// Value returns a driver specific setting stored with SetValue or nil.
+func (c *Config) Value(key any) any {
+	return c.values[key]
+}
+
+// SetValue stores a driver specific setting. Drivers export their own typed
+// options built on SetValue using an unexported key type.
+func (c *Config) SetValue(key, value any) {
+	if c.values == nil {
+		c.values = make(map[any]any)
+	}
+	c.values[key] = value
 }
 
 // Option configures a Config.
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TrimScheme removes a scheme: or scheme:// prefix from dsn, for drivers whose
+// DSN is otherwise a file path, e.g. sqlite:app.db or sqlite:///var/lib/app.db.
+func TrimScheme(dsn, scheme string) string {
+	if rest, ok := strings.CutPrefix(dsn, scheme+"://"); ok {
+		return rest
+	}
+	return strings.TrimPrefix(dsn, scheme+":")
+}
+
+// SplitDSNParams removes params, migrate's own x- parameters, from the query
+// of dsn so the database driver never sees them, returning their values. A dsn
+// without any of them is returned unchanged.
+func SplitDSNParams(dsn string, params ...string) (string, url.Values, error) {
+	base, query, ok := strings.Cut(dsn, "?")
+	if !ok {
+		return dsn, url.Values{}, nil
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse dsn: %w", err)
+	}
+
+	found := url.Values{}
+	for _, param := range params {
+		if values.Has(param) {
+			found[param] = values[param]
+			values.Del(param)
+		}
+	}
+	if len(found) == 0 {
+		return dsn, found, nil
+	}
+	if len(values) == 0 {
+		return base, found, nil
+	}
+	return base + "?" + values.Encode(), found, nil
+}
+
+// DSNParamNeeds fails when param is set without needs, rather than silently
+// ignoring it.
+func DSNParamNeeds(values url.Values, param, needs string) error {
+	if values.Has(param) && !values.Has(needs) {
+		return fmt.Errorf("parse dsn: %s needs %s", param, needs)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package driver_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shanna/migrate/driver"
+)
+
+func TestSplitDSNParams(t *testing.T) {
+	var tests = []struct {
+		name, dsn, want string
+		values          url.Values
+	}{
+		{`no query`, `app.db`, `app.db`, url.Values{}},
+		{`no params`, `app.db?cache=shared&mode=rwc`, `app.db?cache=shared&mode=rwc`, url.Values{}},
+		{`only params`, `app.db?x-a=1&x-b=2&x-b=3`, `app.db`, url.Values{"x-a": {"1"}, "x-b": {"2", "3"}}},
+		{`mixed`, `postgres://localhost/db?sslmode=disable&x-a=1`, `postgres://localhost/db?sslmode=disable`, url.Values{"x-a": {"1"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dsn, values, err := driver.SplitDSNParams(test.dsn, "x-a", "x-b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dsn != test.want {
+				t.Errorf("expected dsn %q, got %q", test.want, dsn)
+			}
+			if diff := cmp.Diff(test.values, values); diff != "" {
+				t.Errorf("values mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, _, err := driver.SplitDSNParams("app.db?x-a=%zz", "x-a"); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestDSNParamNeeds(t *testing.T) {
+	values := url.Values{"x-backoff": {"1s"}}
+	if err := driver.DSNParamNeeds(values, "x-backoff", "x-retry"); err == nil {
+		t.Error("expected error for x-backoff without x-retry")
+	}
+	values.Set("x-retry", "3")
+	if err := driver.DSNParamNeeds(values, "x-backoff", "x-retry"); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestTrimScheme(t *testing.T) {
+	for dsn, want := range map[string]string{
+		"sqlite:app.db":            "app.db",
+		"sqlite:///var/lib/app.db": "/var/lib/app.db",
+		"app.db":                   "app.db",
+		"duckdb:app.db?threads=4":  "duckdb:app.db?threads=4",
+	} {
+		if got := driver.TrimScheme(dsn, "sqlite"); got != want {
+			t.Errorf("%s: expected %q, got %q", dsn, want, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package duckdb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// attachment is a database attached under an alias.
+type attachment struct {
+	alias   string
+	path    string
+	options []string
+}
+
+// execQueryer is satisfied by *sql.DB and *sql.Tx.
+type execQueryer interface {
+	rowQueryer
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+var extensionNameRe = regexp.MustCompile(`^\w+$`)
+
+// quote an identifier.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// literal quotes a string literal.
+func literal(value string) string {
+	return `'` + strings.ReplaceAll(value, `'`, `''`) + `'`
+}
+
+// prepare sets the extension directory, loads extensions and attaches
+// databases ahead of setup so the history catalog can be an attached database.
+func (d *DuckDB) prepare(q execQueryer) error {
+	if d.extensionDir != "" {
+		if _, err := q.Exec(`set global extension_directory = ` + literal(d.extensionDir)); err != nil {
+			return fmt.Errorf("extension directory: %w", err)
+		}
+	}
+
+	for _, name := range d.extensions {
+		if err := d.load(q, name); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range d.attachments {
+		var attached bool
+		err := q.QueryRow(`select count(*) > 0 from duckdb_databases() where database_name = ?`, a.alias).Scan(&attached)
+		if err != nil {
+			return fmt.Errorf("attach %s: %w", a.alias, err)
+		}
+		if attached {
+			continue
+		}
+
+		query := fmt.Sprintf(`attach %s as %s`, literal(a.path), quote(a.alias))
+		if len(a.options) > 0 {
+			query += ` (` + strings.Join(a.options, ", ") + `)`
+		}
+		if _, err := q.Exec(query); err != nil {
+			return fmt.Errorf("attach %s: %w", a.alias, err)
+		}
+	}
+	return nil
+}
+
+// load an extension by name, installing it first unless it is already
+// installed, or load an extension file by path.
+func (d *DuckDB) load(q execQueryer, name string) error {
+	if strings.HasSuffix(name, ".duckdb_extension") || strings.ContainsRune(name, filepath.Separator) {
+		if _, err := q.Exec(`load ` + literal(name)); err != nil {
+			return fmt.Errorf("load extension %s: %w", name, err)
+		}
+		return nil
+	}
+	if !extensionNameRe.MatchString(name) {
+		return fmt.Errorf("load extension %q: invalid name", name)
+	}
+
+	var installed, loaded bool
+	err := q.QueryRow(`select installed, loaded from duckdb_extensions() where extension_name = ?`, name).Scan(&installed, &loaded)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("load extension %s: %w", name, err)
+	}
+	if loaded {
+		return nil
+	}
+	if !installed {
+		if _, err := q.Exec(`install ` + name); err != nil {
+			return fmt.Errorf("install extension %s: %w", name, err)
+		}
+	}
+	if _, err := q.Exec(`load ` + name); err != nil {
+		return fmt.Errorf("load extension %s: %w", name, err)
+	}
+	d.logger.Debug(fmt.Sprintf("migrate extension %s", name), "driver", "duckdb")
+	return nil
+}
@@ -0,0 +1,85 @@
+package duckdb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shanna/migrate/driver"
+)
+
+// path of the database file, empty for an in-memory database.
+func (d *DuckDB) path() (string, error) {
+	var path sql.NullString
+	err := d.db.QueryRow(`select path from duckdb_databases() where database_name = ?`, d.database).Scan(&path)
+	if err != nil {
+		return "", fmt.Errorf("database path: %w", err)
+	}
+	return path.String, nil
+}
+
+// backup checkpoints the write-ahead log into the database file then copies
+// it, once a run before its first migration is applied. The checkpoint runs
+// in the run's transaction, which must not have written anything yet.
+func (d *DuckDB) backup() error {
+	if d.backups.Dir == "" || d.backedUp {
+		return nil
+	}
+
+	path, err := d.path()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New("backup: in-memory databases have no file to back up")
+	}
+
+	if _, err := d.tx.Exec(`checkpoint`); err != nil {
+		return fmt.Errorf("backup checkpoint: %w", err)
+	}
+	if err := os.MkdirAll(d.backups.Dir, 0755); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	snapshot := d.backups.Path(path, time.Now())
+	if err := driver.CopyFile(snapshot, path); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	d.backedUp = true
+	d.logger.Info("migrate backup", "driver", "duckdb", "path", snapshot)
+	return d.backups.Prune(path)
+}
+
+// Restore replaces the database file with its latest snapshot. The database
+// is closed first so only a driver created with New can restore; nothing else
+// may have the file open.
+func (d *DuckDB) Restore() (string, error) {
+	defer d.close()
+
+	if d.backups.Dir == "" {
+		return "", errors.New("restore: no backup directory configured")
+	}
+	if !d.owned {
+		return "", errors.New("restore: the database belongs to the caller")
+	}
+
+	path, err := d.path()
+	if err != nil {
+		return "", err
+	}
+	latest, err := d.backups.Latest(path)
+	if err != nil {
+		return "", err
+	}
+
+	d.db.Close()
+	if err := os.Remove(path + ".wal"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("restore: %w", err)
+	}
+	if err := driver.CopyFile(path, latest); err != nil {
+		return "", fmt.Errorf("restore: %w", err)
+	}
+	d.logger.Info("migrate restore", "driver", "duckdb", "path", latest)
+	return latest, nil
+}
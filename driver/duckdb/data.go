@@ -0,0 +1,56 @@
+package duckdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shanna/migrate/driver"
+)
+
+// Load a data file into an existing table, matching columns by name. DuckDB
+// reads files rather than streams so the data is spooled to a temporary file
+// first.
+func (d *DuckDB) Load(name string, data io.Reader, load driver.Data) error {
+	stream := driver.NewDataStream(data)
+
+	skip, err := d.skip(name, stream.Checksum)
+	if err != nil || skip {
+		return err
+	}
+	if err := d.apply(); err != nil {
+		return err
+	}
+
+	fh, err := os.CreateTemp("", "migrate-*")
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	defer os.Remove(fh.Name())
+	_, err = io.Copy(fh, stream)
+	fh.Close()
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+
+	var read string
+	switch load.Format {
+	case driver.CSV:
+		// Strings are cast to the table's column types rather than guessed.
+		read = fmt.Sprintf(`read_csv(%s, header = true, all_varchar = true)`, literal(fh.Name()))
+	case driver.NDJSON:
+		read = fmt.Sprintf(`read_json(%s, format = 'newline_delimited')`, literal(fh.Name()))
+	case driver.Parquet:
+		read = fmt.Sprintf(`read_parquet(%s)`, literal(fh.Name()))
+	default:
+		return fmt.Errorf("load %s: %s: %w", name, load.Format, driver.ErrUnsupported)
+	}
+
+	query := fmt.Sprintf(`insert into %s by name select * from %s`, load.Table, read)
+	if _, err := d.tx.Exec(query); err != nil {
+		d.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "duckdb", "error", err, "table", load.Table, "format", load.Format.String())
+		return err
+	}
+
+	return d.record(name, stream.Checksum)
+}
@@ -2,9 +2,9 @@ package duckdb
 
 import (
 	"context"
-	"crypto/sha512"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,6 +12,7 @@ import (
 
 	_ "github.com/duckdb/duckdb-go/v2"
 	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
 )
 
 func init() {
@@ -26,14 +27,81 @@ type migrate struct {
 
 type DuckDB struct {
 	db        *sql.DB
+	owned     bool // Close db on Commit or Rollback.
+	joined    bool // tx belongs to the caller.
 	tx        *sql.Tx
 	logger    driver.Logger
-	catalog   string
+	database  string // Catalog of the database opened.
+	catalog   string // Catalog holding the history table.
 	schema    string
 	tableName string
+	backups   driver.Backups
+	backedUp  bool // Snapshot taken this run.
+	history   bool // History table set up this run.
+
+	extensions   []string
+	extensionDir string
+	attachments  []attachment
 }
 
+// New opens dsn, which may carry a duckdb: scheme so the command can pick the
+// driver from it, e.g. duckdb:app.db or duckdb:///var/lib/app.db.
 func New(dsn string, opts ...driver.Option) (driver.Migrator, error) {
+	dsn, dsnOpts, err := dsnOptions(driver.TrimScheme(dsn, "duckdb"))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(dsnOpts, opts...)
+
+	conn, err := sql.Open("duckdb", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sql open: %w", err)
+	}
+
+	d, err := newDuckDB(conn, newConfig(opts))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	d.db = conn
+	d.owned = true
+	return d, nil
+}
+
+// NewFromDB creates a migrator using an existing database handle. The caller
+// keeps ownership and Commit or Rollback never close it.
+func NewFromDB(db *sql.DB, opts ...driver.Option) (driver.Migrator, error) {
+	if db == nil {
+		return nil, errors.New("duckdb: nil database")
+	}
+	d, err := newDuckDB(db, newConfig(opts))
+	if err != nil {
+		return nil, err
+	}
+	d.db = db
+	return d, nil
+}
+
+// NewFromTx creates a migrator that runs inside the caller's transaction.
+// DuckDB has no savepoints so Commit and Rollback leave the transaction
+// untouched; the caller must commit or roll it back.
+func NewFromTx(tx *sql.Tx, opts ...driver.Option) (driver.Migrator, error) {
+	if tx == nil {
+		return nil, errors.New("duckdb: nil transaction")
+	}
+	d, err := newDuckDB(tx, newConfig(opts))
+	if err != nil {
+		return nil, err
+	}
+	if d.backups.Dir != "" {
+		return nil, errors.New("duckdb: backups can't be taken inside a transaction")
+	}
+	d.tx = tx
+	d.joined = true
+	return d, nil
+}
+
+func newConfig(opts []driver.Option) *driver.Config {
 	config := &driver.Config{
 		Logger:    slog.Default(),
 		Schema:    driver.DefaultSchema,
@@ -42,27 +110,46 @@ func New(dsn string, opts ...driver.Option) (driver.Migrator, error) {
 	for _, opt := range opts {
 		opt(config)
 	}
+	return config
+}
 
-	conn, err := sql.Open("duckdb", dsn)
-	if err != nil {
-		return nil, fmt.Errorf("sql open: %w", err)
-	}
+// rowQueryer is satisfied by *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
 
-	var catalog string
-	if err := conn.QueryRow("SELECT current_catalog()").Scan(&catalog); err != nil {
-		conn.Close()
+func newDuckDB(q rowQueryer, config *driver.Config) (*DuckDB, error) {
+	var database string
+	if err := q.QueryRow("SELECT current_catalog()").Scan(&database); err != nil {
 		return nil, fmt.Errorf("get current catalog: %w", err)
 	}
 
-	d := &DuckDB{
-		db:        conn,
+	options := configOptions(config)
+
+	catalog := options.catalog
+	if catalog == "" {
+		catalog = database
+	}
+
+	return &DuckDB{
 		logger:    config.Logger,
+		database:  database,
 		catalog:   catalog,
 		schema:    config.Schema,
 		tableName: config.TableName,
-	}
+		backups:   options.backups,
 
-	return d, nil
+		extensions:   options.extensions,
+		extensionDir: options.extensionDir,
+		attachments:  options.attach,
+	}, nil
+}
+
+// close an owned database.
+func (d *DuckDB) close() {
+	if d.owned {
+		d.db.Close()
+	}
 }
 
 func (d *DuckDB) qualifiedSchemaName() string {
@@ -101,69 +188,155 @@ func (d *DuckDB) insertMigrationSQL() string {
 func (d *DuckDB) Begin() error {
 	ctx := context.TODO()
 
-	transaction, err := d.db.BeginTx(ctx, nil)
-	if err != nil {
+	transaction := d.tx
+	if d.joined {
+		if err := d.prepare(transaction); err != nil {
+			return err
+		}
+	} else {
+		if err := d.prepare(d.db); err != nil {
+			return err
+		}
+
+		var err error
+		if transaction, err = d.db.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+	}
+	d.tx = transaction
+
+	// A checkpoint for the backup fails once the transaction has written
+	// anything, so setup waits for the first migration applied.
+	if d.backups.Dir != "" {
+		return nil
+	}
+	if err := d.setup(); err != nil {
+		if !d.joined {
+			transaction.Rollback()
+		}
 		return err
 	}
+	return nil
+}
 
-	// Setup creates schema/table if needed.
-	// DuckDB uses file-level locking for serialization.
-	if _, err := transaction.Exec(d.setupSQL()); err != nil {
-		transaction.Rollback()
+// setup creates the history schema and table if needed.
+// DuckDB uses file-level locking for serialization.
+func (d *DuckDB) setup() error {
+	if d.history {
+		return nil
+	}
+	if _, err := d.tx.Exec(d.setupSQL()); err != nil {
 		return fmt.Errorf("setup: %w", err)
 	}
-
-	d.tx = transaction
+	d.history = true
 	return nil
 }
 
+// apply readies the run for a migration that hasn't run, taking the backup
+// and setting up the history table before the first.
+func (d *DuckDB) apply() error {
+	if err := d.backup(); err != nil {
+		return err
+	}
+	return d.setup()
+}
+
 func (d *DuckDB) Rollback() error {
-	defer d.db.Close()
+	defer d.close()
+	if d.joined {
+		return nil
+	}
 	return d.tx.Rollback()
 }
 
 func (d *DuckDB) Commit() error {
-	defer d.db.Close()
+	defer d.close()
+	if d.joined {
+		return nil
+	}
 	return d.tx.Commit()
 }
 
 func (d *DuckDB) Migrate(name string, data io.Reader) error {
-	// Shame you can't stream statements to the driver as well.
-	checksum := sha512.New()
-	reader := io.TeeReader(data, checksum)
-	statements, err := io.ReadAll(reader)
-	if err != nil {
-		d.tx.Rollback()
-		return fmt.Errorf("read: %w", err)
+	stream := driver.NewStream(data, statement.DuckDB)
+
+	skip, err := d.skip(name, stream.Checksum)
+	if err != nil || skip {
+		return err
+	}
+	if err := d.apply(); err != nil {
+		return err
+	}
+
+	for stream.Scan() {
+		stmt := stream.Statement()
+		if _, err := d.tx.Exec(stmt.SQL); err != nil {
+			d.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "duckdb", "error", err, "statement", stmt.Index+1, "sql", stmt.SQL)
+			return err
+		}
+	}
+
+	return d.record(name, stream.Checksum)
+}
+
+// skip reports whether name has already run, failing if it has been altered
+// since.
+func (d *DuckDB) skip(name string, checksum func() ([]byte, error)) (bool, error) {
+	if !d.history {
+		exists, err := d.historyExists()
+		if err != nil || !exists {
+			return false, err
+		}
 	}
 
 	rows, err := d.tx.Query(d.selectMigrationSQL(), name)
 	if err != nil {
-		return fmt.Errorf("schema_migrations select previous %s", err)
+		return false, fmt.Errorf("schema_migrations select previous %s", err)
 	}
 	defer rows.Close()
 
-	if rows.Next() {
-		previous := migrate{}
-		err = rows.Scan(&previous.name, &previous.completed, &previous.checksum)
-		if err != nil {
-			return fmt.Errorf("schema_migrations scan previous %s", err)
-		}
-		if base64.StdEncoding.EncodeToString(checksum.Sum(nil)) != previous.checksum {
-			return fmt.Errorf("%q has been altered since it was run on %s", previous.name, previous.completed)
-		}
+	if !rows.Next() {
+		return false, nil
+	}
 
-		d.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "duckdb", "completed", previous.completed)
-		return nil
+	previous := migrate{}
+	err = rows.Scan(&previous.name, &previous.completed, &previous.checksum)
+	if err != nil {
+		return false, fmt.Errorf("schema_migrations scan previous %s", err)
 	}
 	rows.Close()
 
-	if _, err := d.tx.Exec(string(statements)); err != nil {
-		d.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "duckdb", "error", err, "sql", string(statements))
-		return err
+	sum, err := checksum()
+	if err != nil {
+		return false, fmt.Errorf("read: %w", err)
+	}
+	if base64.StdEncoding.EncodeToString(sum) != previous.checksum {
+		return false, fmt.Errorf("%q has been altered since it was run on %s", previous.name, previous.completed)
+	}
+
+	d.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "duckdb", "completed", previous.completed)
+	return true, nil
+}
+
+// historyExists reports whether the history table has been created.
+func (d *DuckDB) historyExists() (bool, error) {
+	var count int
+	err := d.tx.QueryRow(`select count(*) from duckdb_tables() where database_name = ? and schema_name = ? and table_name = ?`,
+		d.catalog, d.schema, d.tableName).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("schema_migrations exists %s", err)
+	}
+	return count > 0, nil
+}
+
+// record name as run in the history table.
+func (d *DuckDB) record(name string, checksum func() ([]byte, error)) error {
+	sum, err := checksum()
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
 	}
 
-	if _, err = d.tx.Exec(d.insertMigrationSQL(), name, base64.StdEncoding.EncodeToString(checksum.Sum(nil))); err != nil {
+	if _, err = d.tx.Exec(d.insertMigrationSQL(), name, base64.StdEncoding.EncodeToString(sum)); err != nil {
 		return fmt.Errorf("schema_migrations insert %s", err)
 	}
 
@@ -2,11 +2,14 @@ package duckdb_test
 
 import (
 	"database/sql"
+	"io"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/duckdb/duckdb-go/v2"
+	mdriver "github.com/shanna/migrate/driver"
 	driver "github.com/shanna/migrate/driver/duckdb"
 )
 
@@ -144,3 +147,221 @@ func TestDuckDBMigrateChecksumRollback(t *testing.T) {
 		t.Fatalf("expected table to not exist after rollback")
 	}
 }
+
+func TestDuckDBNewFromDB(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open("duckdb", dir+"/migrate.db")
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	defer db.Close()
+
+	migrator, err := driver.NewFromDB(db)
+	if err != nil {
+		t.Fatalf("new from db %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate(`migrate from db`, strings.NewReader(`create table from_db_test (id text)`)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	// The handle belongs to the caller and must still be open.
+	rows, err := db.Query(`select id from from_db_test`)
+	if err != nil {
+		t.Fatalf("post migrate select %s", err)
+	}
+	rows.Close()
+}
+
+func TestDuckDBBackupRestore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := "duckdb:" + dir + "/migrate.db?x-backup=" + dir + "/backups"
+	migrations := []struct{ name, sql string }{
+		{`migrate backup: create`, `create table backup_test (id integer)`},
+		{`migrate backup: insert`, `insert into backup_test values (1)`},
+	}
+	// The last run applies nothing so takes no snapshot.
+	for _, migration := range append(migrations, migrations[1]) {
+		migrator, err := driver.New(dsn)
+		if err != nil {
+			t.Fatalf("connect %s", err)
+		}
+		if err = migrator.Begin(); err != nil {
+			t.Fatalf("begin %s", err)
+		}
+		if err = migrator.Migrate(migration.name, strings.NewReader(migration.sql)); err != nil {
+			t.Fatalf("%s %s", migration.name, err)
+		}
+		if err = migrator.Commit(); err != nil {
+			t.Fatalf("commit %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	backups, _ := os.ReadDir(dir + "/backups")
+	if len(backups) != len(migrations) {
+		t.Fatalf("expected %d backups, got %d", len(migrations), len(backups))
+	}
+
+	migrator, err := driver.New(dsn)
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	if _, err = migrator.(mdriver.Restorer).Restore(); err != nil {
+		t.Fatalf("restore %s", err)
+	}
+
+	// Restored to just before the insert.
+	db, err := sql.Open("duckdb", dir+"/migrate.db")
+	if err != nil {
+		t.Fatalf("post restore connect %s", err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow(`select count(*) from backup_test`).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("expected empty table after restore, got %d %v", count, err)
+	}
+}
+
+func TestDuckDBAttachCatalog(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source, err := sql.Open("duckdb", dir+"/source.db")
+	if err != nil {
+		t.Fatalf("source connect %s", err)
+	}
+	if _, err := source.Exec(`create table raw (payload json); insert into raw values ('{"id": 1}')`); err != nil {
+		t.Fatalf("source setup %s", err)
+	}
+	source.Close()
+
+	migrator, err := driver.New(
+		"duckdb:"+dir+"/migrate.db?x-extension=json&x-extension-dir="+dir+"/extensions&x-attach=warehouse="+dir+"/warehouse.db",
+		driver.WithAttach("source", dir+"/source.db", "READ_ONLY"),
+		driver.WithCatalog("warehouse"),
+	)
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	migration := `create table warehouse.main.events as select payload->>'id' as id from source.main.raw`
+	if err = migrator.Migrate("migrate attach: create", strings.NewReader(migration)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	warehouse, err := sql.Open("duckdb", dir+"/warehouse.db")
+	if err != nil {
+		t.Fatalf("post migrate connect %s", err)
+	}
+	defer warehouse.Close()
+	var name string
+	if err := warehouse.QueryRow(`select name from migrate.schema_migrations`).Scan(&name); err != nil {
+		t.Fatalf("history select %s", err)
+	}
+	if name != "migrate attach: create" {
+		t.Errorf("expected history in attached catalog, got %q", name)
+	}
+	var id string
+	if err := warehouse.QueryRow(`select id from events`).Scan(&id); err != nil || id != "1" {
+		t.Errorf("expected events copied from source, got %q %v", id, err)
+	}
+}
+
+func TestDuckDBLoad(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Parquet written by DuckDB itself.
+	source, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatalf("source connect %s", err)
+	}
+	_, err = source.Exec(`copy (select 'FR' as code, 'France' as name) to '` + dir + `/countries.parquet' (format parquet)`)
+	source.Close()
+	if err != nil {
+		t.Fatalf("parquet %s", err)
+	}
+	parquet, err := os.Open(dir + "/countries.parquet")
+	if err != nil {
+		t.Fatalf("parquet %s", err)
+	}
+	defer parquet.Close()
+
+	migrator, err := driver.New(dir + "/app.db")
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	schema := `create table countries (code text primary key, name text, population bigint default 0)`
+	if err = migrator.Migrate("001-schema.sql", strings.NewReader(schema)); err != nil {
+		t.Fatalf("schema %s", err)
+	}
+
+	loader := migrator.(mdriver.Loader)
+	var loads = []struct {
+		name string
+		data io.Reader
+		load mdriver.Data
+	}{
+		{`002-countries.csv`, strings.NewReader("name,code,population\nNew Zealand,NZ,5000000\n"), mdriver.Data{Table: "countries", Format: mdriver.CSV}},
+		{`003-countries.ndjson`, strings.NewReader(`{"code": "AU", "name": "Australia"}` + "\n"), mdriver.Data{Table: "countries", Format: mdriver.NDJSON}},
+		{`004-countries.parquet`, parquet, mdriver.Data{Table: "countries", Format: mdriver.Parquet}},
+	}
+	for _, load := range loads {
+		if err = loader.Load(load.name, load.data, load.load); err != nil {
+			t.Fatalf("%s %s", load.name, err)
+		}
+	}
+
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("duckdb", dir+"/app.db")
+	if err != nil {
+		t.Fatalf("post migrate connect %s", err)
+	}
+	defer db.Close()
+
+	var got string
+	err = db.QueryRow(`select string_agg(code || ':' || population, ',' order by code) from countries`).Scan(&got)
+	if err != nil || got != "AU:0,FR:0,NZ:5000000" {
+		t.Errorf("expected loaded countries, got %q %v", got, err)
+	}
+	var history int
+	if err := db.QueryRow(`select count(*) from migrate.schema_migrations`).Scan(&history); err != nil || history != 4 {
+		t.Errorf("expected 4 history rows, got %d %v", history, err)
+	}
+}
@@ -0,0 +1,134 @@
+package duckdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shanna/migrate/driver"
+)
+
+// options specific to the DuckDB driver.
+type options struct {
+	backups      driver.Backups
+	extensions   []string
+	extensionDir string
+	attach       []attachment
+	catalog      string
+}
+
+type optionsKey struct{}
+
+// option returns a driver.Option updating the DuckDB specific options.
+func option(f func(*options)) driver.Option {
+	return func(c *driver.Config) {
+		o, _ := c.Value(optionsKey{}).(*options)
+		if o == nil {
+			o = &options{}
+			c.SetValue(optionsKey{}, o)
+		}
+		f(o)
+	}
+}
+
+// configOptions returns the DuckDB specific options set on config.
+func configOptions(config *driver.Config) options {
+	if o, ok := config.Value(optionsKey{}).(*options); ok {
+		return *o
+	}
+	return options{}
+}
+
+// WithBackup checkpoints the database and copies its file into dir before
+// the first migration a run applies, keeping the keep most recent snapshots or
+// all when keep is zero. Also set with the x-backup=dir and x-backup-keep DSN
+// parameters. Use Restore, or migrate restore, to put the latest snapshot back.
+func WithBackup(dir string, keep int) driver.Option {
+	return option(func(o *options) {
+		o.backups = driver.Backups{Dir: dir, Keep: keep}
+	})
+}
+
+// WithExtension installs and loads a DuckDB extension such as spatial before
+// migrating. Extensions already installed, or built in, aren't installed
+// again so no network access is needed for them. Name may instead be the path
+// to a .duckdb_extension file, which is loaded directly. Also set with one or
+// more x-extension DSN parameters.
+func WithExtension(name string) driver.Option {
+	return option(func(o *options) {
+		o.extensions = append(o.extensions, name)
+	})
+}
+
+// WithExtensionDirectory sets the directory extensions are installed into and
+// loaded from, so offline machines can use extensions copied there ahead of
+// time. Also set with the x-extension-dir DSN parameter.
+func WithExtensionDirectory(dir string) driver.Option {
+	return option(func(o *options) {
+		o.extensionDir = dir
+	})
+}
+
+// WithAttach attaches the database at path as alias before migrating, so
+// migrations can use alias.schema.table. Path may carry a type prefix such as
+// sqlite:data.db and attach options are passed to ATTACH as written, e.g.
+// READ_ONLY or TYPE sqlite. Also set with one or more x-attach=alias=path DSN
+// parameters.
+func WithAttach(alias, path string, attachOptions ...string) driver.Option {
+	return option(func(o *options) {
+		o.attach = append(o.attach, attachment{alias: alias, path: path, options: attachOptions})
+	})
+}
+
+// WithCatalog keeps the history table in catalog, which may be an attached
+// database, instead of the current catalog. Also set with the x-catalog DSN
+// parameter.
+//
+// A DuckDB transaction can only write to one database, so migrations run with
+// an attached history catalog may only write to that database.
+func WithCatalog(catalog string) driver.Option {
+	return option(func(o *options) {
+		o.catalog = catalog
+	})
+}
+
+// dsnOptions strips migrate's own x- parameters from dsn, which DuckDB would
+// otherwise reject as unknown settings, returning them as options.
+func dsnOptions(dsn string) (string, []driver.Option, error) {
+	dsn, values, err := driver.SplitDSNParams(dsn, "x-backup", "x-backup-keep", "x-extension", "x-extension-dir", "x-attach", "x-catalog")
+	if err != nil || len(values) == 0 {
+		return dsn, nil, err
+	}
+	if err := driver.DSNParamNeeds(values, "x-backup-keep", "x-backup"); err != nil {
+		return "", nil, err
+	}
+
+	var opts []driver.Option
+	if values.Has("x-backup") {
+		keep := 0
+		if values.Has("x-backup-keep") {
+			var err error
+			if keep, err = strconv.Atoi(values.Get("x-backup-keep")); err != nil {
+				return "", nil, fmt.Errorf("parse dsn: x-backup-keep: %w", err)
+			}
+		}
+		opts = append(opts, WithBackup(values.Get("x-backup"), keep))
+	}
+	for _, name := range values["x-extension"] {
+		opts = append(opts, WithExtension(name))
+	}
+	if values.Has("x-extension-dir") {
+		opts = append(opts, WithExtensionDirectory(values.Get("x-extension-dir")))
+	}
+	for _, value := range values["x-attach"] {
+		alias, path, ok := strings.Cut(value, "=")
+		if !ok || alias == "" || path == "" {
+			return "", nil, fmt.Errorf("parse dsn: x-attach %q is not alias=path", value)
+		}
+		opts = append(opts, WithAttach(alias, path))
+	}
+	if values.Has("x-catalog") {
+		opts = append(opts, WithCatalog(values.Get("x-catalog")))
+	}
+	return dsn, opts, nil
+}
@@ -0,0 +1,32 @@
+package driver
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLocked is returned by Begin when another run holds the migration lock.
+var ErrLocked = errors.New("another migration is running")
+
+// ErrUnsupported is returned when a driver doesn't implement an optional feature.
+var ErrUnsupported = errors.New("not supported by driver")
+
+// LockInfo describes the holder of a migration lock stored in the database.
+type LockInfo struct {
+	Owner    string
+	Host     string
+	Acquired time.Time
+	Expires  time.Time
+}
+
+// Held reports whether the lock is held and its lease hasn't expired.
+func (l LockInfo) Held(now time.Time) bool {
+	return l.Owner != "" && now.Before(l.Expires)
+}
+
+// Locker is implemented by drivers whose migration lock lives in the database
+// so it can be inspected, or broken after a crashed run, out of band.
+type Locker interface {
+	LockInfo() (LockInfo, error)
+	BreakLock() error
+}
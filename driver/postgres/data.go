@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shanna/migrate/driver"
+)
+
+// batchRows is how many NDJSON objects are inserted at a time.
+const batchRows = 1000
+
+// Load a data file into an existing table. CSV is streamed with COPY FROM
+// STDIN using the header row as the column list. NDJSON objects are inserted
+// in batches through jsonb_populate_recordset so missing keys take the column
+// default. Parquet isn't supported.
+func (p *Postgres) Load(name string, data io.Reader, load driver.Data) error {
+	ctx := context.Background()
+
+	if err := p.ping(ctx); err != nil {
+		return err
+	}
+
+	stream := driver.NewDataStream(data)
+
+	skip, err := p.skip(ctx, name, stream.Checksum)
+	if err != nil || skip {
+		return err
+	}
+
+	p.migration = name
+	defer func() { p.migration = "" }()
+	defer p.watch(ctx, name)()
+
+	switch load.Format {
+	case driver.CSV:
+		err = p.copyCSV(ctx, load.Table, stream)
+	case driver.NDJSON:
+		err = p.insertNDJSON(ctx, load.Table, stream)
+	default:
+		return fmt.Errorf("load %s: %s: %w", name, load.Format, driver.ErrUnsupported)
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			p.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "postgres", "error", err, "code", pgErr.Code, "table", load.Table, "format", load.Format.String())
+		} else {
+			p.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "postgres", "error", err, "table", load.Table, "format", load.Format.String())
+		}
+		return err
+	}
+
+	return p.record(ctx, name, stream.Checksum)
+}
+
+// copyCSV streams CSV rows after the header row into table.
+func (p *Postgres) copyCSV(ctx context.Context, table string, r io.Reader) error {
+	reader := bufio.NewReader(r)
+	header, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("csv header: %w", err)
+	}
+	columns, err := csv.NewReader(strings.NewReader(header)).Read()
+	if err != nil {
+		return fmt.Errorf("csv header: %w", err)
+	}
+
+	query := fmt.Sprintf(`copy %s (%s) from stdin with (format csv)`, table, identifiers(columns))
+	if _, err := p.tx.Conn().PgConn().CopyFrom(ctx, reader, query); err != nil {
+		return err
+	}
+	return nil
+}
+
+// insertNDJSON inserts consecutive objects with the same keys together.
+func (p *Postgres) insertNDJSON(ctx context.Context, table string, r io.Reader) error {
+	var (
+		columns []string
+		objects []json.RawMessage
+	)
+
+	flush := func() error {
+		if len(objects) == 0 {
+			return nil
+		}
+		list := identifiers(columns)
+		query := fmt.Sprintf(`insert into %s (%s) select %s from jsonb_populate_recordset(null::%s, $1::jsonb)`, table, list, list, table)
+		array, err := json.Marshal(objects)
+		if err != nil {
+			return err
+		}
+		objects = objects[:0]
+		_, err = p.tx.Exec(ctx, query, string(array))
+		return err
+	}
+
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(text) == 0 {
+			break
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("ndjson line %d: %w", line, err)
+		}
+		if len(bytes.TrimSpace(text)) == 0 {
+			continue
+		}
+
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(text, &object); err != nil {
+			return fmt.Errorf("ndjson line %d: %w", line, err)
+		}
+		if len(object) == 0 {
+			return fmt.Errorf("ndjson line %d: no columns", line)
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if !slices.Equal(keys, columns) || len(objects) == batchRows {
+			if err := flush(); err != nil {
+				return err
+			}
+			columns = keys
+		}
+		raw, err := json.Marshal(object)
+		if err != nil {
+			return fmt.Errorf("ndjson line %d: %w", line, err)
+		}
+		objects = append(objects, raw)
+	}
+	return flush()
+}
+
+// identifiers quotes and joins column names.
+func identifiers(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shanna/migrate/statement"
+)
+
+// maxLogSQL bounds the characters of a failed statement logged as sql.
+const maxLogSQL = 200
+
+// Error is a server error from a migration statement located in the migration
+// file, much as psql reports it.
+type Error struct {
+	File      string // Migration, or the included file in psql mode.
+	Statement int    // 1-based index of the statement in File.
+	// Line and Column are 1-based, counting characters. Without a position
+	// from the server Column is zero and Line is where the statement starts.
+	Line   int
+	Column int
+	// Source is the statement's text on Line.
+	Source string
+	Code   string // SQLSTATE.
+	Detail string
+	Hint   string
+	Where  string
+	Err    *pgconn.PgError
+
+	caret int // Characters of Source before Column.
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:%d", e.File, e.Line)
+	if e.Column > 0 {
+		fmt.Fprintf(&b, ":%d", e.Column)
+	}
+	fmt.Fprintf(&b, ": %s (SQLSTATE %s)", e.Err.Message, e.Code)
+
+	if e.Column > 0 {
+		fmt.Fprintf(&b, "\n  %s\n  %s", e.Source, caret(e.Source, e.caret))
+	}
+	for _, field := range []struct{ name, value string }{{"DETAIL", e.Detail}, {"HINT", e.Hint}, {"WHERE", e.Where}} {
+		if field.value != "" {
+			fmt.Fprintf(&b, "\n%s: %s", field.name, field.value)
+		}
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError locates pgErr in file from its position in sent, the text of stmt
+// as sent to the server. Positions only map onto the file when sent is the
+// statement's own text, so psql variables that were interpolated, or an empty
+// sent, leave the error at the start of stmt.
+func newError(file string, stmt statement.Statement, sent string, pgErr *pgconn.PgError) *Error {
+	e := &Error{
+		File:      file,
+		Statement: stmt.Index + 1,
+		Line:      stmt.Line,
+		Code:      pgErr.Code,
+		Detail:    pgErr.Detail,
+		Hint:      pgErr.Hint,
+		Where:     pgErr.Where,
+		Err:       pgErr,
+	}
+
+	// Errors inside a function body, such as a DO block, are positioned in the
+	// internal query which is found in the statement itself.
+	if sent != stmt.SQL {
+		return e
+	}
+	sql := stmt.SQL
+
+	position := int(pgErr.Position)
+	if position == 0 && pgErr.InternalPosition > 0 && pgErr.InternalQuery != "" {
+		if i := strings.Index(sql, pgErr.InternalQuery); i >= 0 {
+			position = utf8.RuneCountInString(sql[:i]) + int(pgErr.InternalPosition)
+		}
+	}
+	if position == 0 || sql == "" {
+		return e
+	}
+
+	line, column, start, n := 0, 0, 0, 0
+	for i, r := range sql {
+		if n == position-1 {
+			break
+		}
+		n++
+		if r == '\n' {
+			line, column, start = line+1, 0, i+1
+			continue
+		}
+		column++
+	}
+
+	source := sql[start:]
+	if end := strings.IndexByte(source, '\n'); end >= 0 {
+		source = source[:end]
+	}
+
+	e.Line = stmt.Line + line
+	e.Column = column + 1
+	if line == 0 {
+		e.Column += stmt.Column - 1
+	}
+	e.Source = strings.TrimRight(source, "\r")
+	e.caret = column
+	return e
+}
+
+// caret points at the character after n in source, keeping tabs so it lines up.
+func caret(source string, n int) string {
+	var b strings.Builder
+	for _, r := range source {
+		if n == 0 {
+			break
+		}
+		n--
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	for ; n > 0; n-- {
+		b.WriteByte(' ')
+	}
+	b.WriteByte('^')
+	return b.String()
+}
+
+// truncate sql for logging to at most maxLogSQL characters.
+func truncate(sql string) string {
+	if utf8.RuneCountInString(sql) <= maxLogSQL {
+		return sql
+	}
+	return string([]rune(sql)[:maxLogSQL]) + "…"
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shanna/migrate/statement"
+)
+
+func TestNewError(t *testing.T) {
+	script := "create table users (id int);   select id,\n\temial from users;\n" +
+		"do $$ begin\n  insert into nope values (1);\nend $$;\n"
+	statements := statement.Split(script, statement.Postgres)
+
+	tests := []struct {
+		name   string
+		stmt   statement.Statement
+		sent   string
+		pgErr  *pgconn.PgError
+		want   string
+		line   int
+		column int
+	}{
+		{
+			name:   "position",
+			stmt:   statements[1],
+			pgErr:  &pgconn.PgError{Message: `column "emial" does not exist`, Code: "42703", Position: 13, Hint: `Perhaps you meant to reference the column "users.email".`},
+			want:   "0001_users.sql:2:2: column \"emial\" does not exist (SQLSTATE 42703)\n  \temial from users\n  \t^\nHINT: Perhaps you meant to reference the column \"users.email\".",
+			line:   2,
+			column: 2,
+		},
+		{
+			name:   "first line",
+			stmt:   statements[1],
+			pgErr:  &pgconn.PgError{Message: "syntax error", Code: "42601", Position: 8},
+			want:   "0001_users.sql:1:39: syntax error (SQLSTATE 42601)\n  select id,\n         ^",
+			line:   1,
+			column: 39,
+		},
+		{
+			name: "internal position",
+			stmt: statements[2],
+			pgErr: &pgconn.PgError{Message: `relation "nope" does not exist`, Code: "42P01", InternalPosition: 13, InternalQuery: "insert into nope values (1)",
+				Where: "PL/pgSQL function inline_code_block line 2 at SQL statement"},
+			want:   "0001_users.sql:4:15: relation \"nope\" does not exist (SQLSTATE 42P01)\n    insert into nope values (1);\n                ^\nWHERE: PL/pgSQL function inline_code_block line 2 at SQL statement",
+			line:   4,
+			column: 15,
+		},
+		{
+			name:   "interpolated",
+			stmt:   statements[1],
+			sent:   "select id,\n\temial from app.users",
+			pgErr:  &pgconn.PgError{Message: `column "emial" does not exist`, Code: "42703", Position: 13},
+			want:   "0001_users.sql:1: column \"emial\" does not exist (SQLSTATE 42703)",
+			line:   1,
+			column: 0,
+		},
+		{
+			name:   "no position",
+			stmt:   statements[2],
+			pgErr:  &pgconn.PgError{Message: "permission denied", Code: "42501", Detail: "owner only"},
+			want:   "0001_users.sql:3: permission denied (SQLSTATE 42501)\nDETAIL: owner only",
+			line:   3,
+			column: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := tt.stmt.SQL
+			if tt.sent != "" {
+				sent = tt.sent
+			}
+			err := newError("0001_users.sql", tt.stmt, sent, tt.pgErr)
+			if diff := cmp.Diff(tt.want, err.Error()); diff != "" {
+				t.Errorf("error mismatch (-want +got):\n%s", diff)
+			}
+			if err.Line != tt.line || err.Column != tt.column {
+				t.Errorf("expected line %d column %d, got line %d column %d", tt.line, tt.column, err.Line, err.Column)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("select 1"); got != "select 1" {
+		t.Errorf("expected short sql unchanged, got %q", got)
+	}
+	long := strings.Repeat("é", maxLogSQL+10)
+	if got := truncate(long); got != strings.Repeat("é", maxLogSQL)+"…" {
+		t.Errorf("expected %d characters and an ellipsis, got %q", maxLogSQL, got)
+	}
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shanna/migrate/driver"
+)
+
+// lockPoll is how often a held advisory lock is retried while waiting.
+const lockPoll = 250 * time.Millisecond
+
+// ErrLocked is returned by Begin when another migration holds the advisory lock.
+var ErrLocked = driver.ErrLocked
+
+// LockError describes the session holding the advisory lock.
+type LockError struct {
+	PID         int
+	Application string
+	Since       time.Time
+}
+
+func (e *LockError) Error() string {
+	if e.PID == 0 {
+		return ErrLocked.Error()
+	}
+	message := fmt.Sprintf("%s (pid %d", ErrLocked, e.PID)
+	if e.Application != "" {
+		message += fmt.Sprintf(", application %q", e.Application)
+	}
+	if !e.Since.IsZero() {
+		message += fmt.Sprintf(", since %s", e.Since.Format(time.RFC3339))
+	}
+	return message + ")"
+}
+
+func (e *LockError) Unwrap() error {
+	return ErrLocked
+}
+
+// lockKey is the advisory lock key for the qualified history table name.
+func (p *Postgres) lockKey() int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(p.qualifiedTableName()))
+	return int64(hash.Sum64())
+}
+
+// lock takes a transaction scoped advisory lock, waiting up to
+// p.lockWait for another migration to release it.
+func (p *Postgres) lock(ctx context.Context, tx pgx.Tx) error {
+	key := p.lockKey()
+	deadline := time.Now().Add(p.lockWait)
+
+	for {
+		var locked bool
+		if err := tx.QueryRow(ctx, `select pg_try_advisory_xact_lock($1)`, key).Scan(&locked); err != nil {
+			return fmt.Errorf("advisory lock: %w", err)
+		}
+		if locked {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return p.lockHolder(ctx, tx, key)
+		}
+		p.logger.Debug("migrate waiting for advisory lock", "driver", "postgres", "key", key)
+		time.Sleep(lockPoll)
+	}
+}
+
+// lockHolder builds a LockError for the session holding the advisory lock.
+func (p *Postgres) lockHolder(ctx context.Context, tx pgx.Tx, key int64) error {
+	holder := &LockError{}
+	var (
+		application *string
+		since       *time.Time
+	)
+	err := tx.QueryRow(ctx, `
+select l.pid, a.application_name, a.xact_start
+from pg_locks l
+left join pg_stat_activity a on a.pid = l.pid
+where l.locktype = 'advisory'
+  and l.granted
+  and l.objsubid = 1
+  and l.classid = (($1::bigint >> 32) & 4294967295)::oid
+  and l.objid = ($1::bigint & 4294967295)::oid
+limit 1;
+`, key).Scan(&holder.PID, &application, &since)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %w", holder, err)
+	}
+	if application != nil {
+		holder.Application = *application
+	}
+	if since != nil {
+		holder.Since = *since
+	}
+	return holder
+}
@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shanna/migrate/driver"
+)
+
+// Notice is a message the server raised while migrating, such as a RAISE
+// NOTICE progress report from a long running data migration.
+type Notice struct {
+	Migration string // Empty outside of a migration.
+	Severity  string // NOTICE, WARNING, INFO, LOG or DEBUG.
+	Code      string // SQLSTATE.
+	Message   string
+	Detail    string
+	Hint      string
+}
+
+// WithNoticeHandler calls handler with every notice the server raises on a
+// connection opened by New, as well as logging it. Handlers run while the
+// statement that raised the notice is executing so should return quickly.
+// Connections given to NewFromConn keep whatever OnNotice they were opened
+// with.
+func WithNoticeHandler(handler func(Notice)) driver.Option {
+	return option(func(o *options) {
+		o.noticeHandler = handler
+	})
+}
+
+// warner is implemented by loggers with a warning level such as *slog.Logger.
+type warner interface {
+	Warn(msg string, args ...any)
+}
+
+// warn logs at the warning level when the logger has one, otherwise info.
+func (p *Postgres) warn(msg string, args ...any) {
+	if warn, ok := p.logger.(warner); ok {
+		warn.Warn(msg, args...)
+		return
+	}
+	p.logger.Info(msg, args...)
+}
+
+// onNotice logs a server notice against the running migration and passes it
+// to the notice handler.
+func (p *Postgres) onNotice(_ *pgconn.PgConn, n *pgconn.Notice) {
+	notice := Notice{
+		Migration: p.migration,
+		Severity:  n.SeverityUnlocalized,
+		Code:      n.Code,
+		Message:   n.Message,
+		Detail:    n.Detail,
+		Hint:      n.Hint,
+	}
+	if notice.Severity == "" {
+		notice.Severity = n.Severity
+	}
+
+	args := []any{"driver", "postgres", "migration", notice.Migration, "severity", notice.Severity, "code", notice.Code}
+	if notice.Detail != "" {
+		args = append(args, "detail", notice.Detail)
+	}
+	if notice.Hint != "" {
+		args = append(args, "hint", notice.Hint)
+	}
+
+	// Outside a migration notices come from setup, e.g. "already exists,
+	// skipping", so are only of interest when debugging.
+	switch warn, ok := p.logger.(warner); {
+	case notice.Migration == "" || strings.HasPrefix(notice.Severity, "DEBUG"):
+		p.logger.Debug(notice.Message, args...)
+	case notice.Severity == "WARNING" && ok:
+		warn.Warn(notice.Message, args...)
+	default:
+		p.logger.Info(notice.Message, args...)
+	}
+
+	if p.noticeHandler != nil {
+		p.noticeHandler(notice)
+	}
+}
@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shanna/migrate/driver"
+)
+
+// options specific to the Postgres driver.
+type options struct {
+	advisoryLock bool
+	lockWait     time.Duration
+
+	psql     bool
+	includes fs.FS
+	psqlVars map[string]string
+
+	lockRetries int
+	lockBackoff time.Duration
+
+	watchdog       time.Duration
+	watchdogAction WatchdogAction
+	watchdogAfter  time.Duration
+
+	pgbouncer bool
+
+	noticeHandler func(Notice)
+	session       map[string]string // SET LOCAL name to value.
+}
+
+type optionsKey struct{}
+
+// option returns a driver.Option updating the Postgres specific options.
+func option(f func(*options)) driver.Option {
+	return func(c *driver.Config) {
+		o, _ := c.Value(optionsKey{}).(*options)
+		if o == nil {
+			o = &options{}
+			c.SetValue(optionsKey{}, o)
+		}
+		f(o)
+	}
+}
+
+// configOptions returns the Postgres specific options set on config.
+func configOptions(config *driver.Config) options {
+	if o, ok := config.Value(optionsKey{}).(*options); ok {
+		return *o
+	}
+	return options{}
+}
+
+// WithAdvisoryLock serialises runs with a transaction scoped advisory lock
+// keyed on the history table rather than locking the history table itself, so
+// read-only queries against the history aren't blocked. Begin waits up to wait
+// for a running migration to finish; zero fails straight away.
+func WithAdvisoryLock(wait time.Duration) driver.Option {
+	return option(func(o *options) {
+		o.advisoryLock = true
+		o.lockWait = wait
+	})
+}
+
+// dsnOptions strips migrate's own x- parameters from a URL dsn, which pgx
+// would otherwise send to the server as settings, returning them as options.
+func dsnOptions(dsn string) (string, []driver.Option, error) {
+	dsn, values, err := driver.SplitDSNParams(dsn, "x-psql", "x-psql-var", "x-role", "x-search-path", "x-lock-timeout", "x-statement-timeout",
+		"x-idle-in-transaction-timeout", "x-lock-retry", "x-lock-retry-backoff", "x-watchdog", "x-watchdog-action", "x-watchdog-after", "x-pgbouncer")
+	if err != nil || len(values) == 0 {
+		return dsn, nil, err
+	}
+	for _, needs := range [][2]string{{"x-lock-retry-backoff", "x-lock-retry"}, {"x-watchdog-action", "x-watchdog"}, {"x-watchdog-after", "x-watchdog-action"}} {
+		if err := driver.DSNParamNeeds(values, needs[0], needs[1]); err != nil {
+			return "", nil, err
+		}
+	}
+
+	var opts []driver.Option
+	if values.Has("x-psql") {
+		opts = append(opts, WithPsql(os.DirFS(values.Get("x-psql"))))
+	}
+	for _, raw := range values["x-psql-var"] {
+		name, value, ok := strings.Cut(raw, "=")
+		if !ok || name == "" {
+			return "", nil, fmt.Errorf("parse dsn: x-psql-var %q is not name=value", raw)
+		}
+		opts = append(opts, WithPsqlVar(name, value))
+	}
+
+	if values.Has("x-role") {
+		opts = append(opts, WithRole(values.Get("x-role")))
+	}
+	if values.Has("x-search-path") {
+		opts = append(opts, WithSearchPath(strings.Split(values.Get("x-search-path"), ",")...))
+	}
+	timeouts := []struct {
+		param  string
+		option func(time.Duration) driver.Option
+	}{
+		{"x-lock-timeout", WithLockTimeout},
+		{"x-statement-timeout", WithStatementTimeout},
+		{"x-idle-in-transaction-timeout", WithIdleInTransactionTimeout},
+	}
+	for _, timeout := range timeouts {
+		if !values.Has(timeout.param) {
+			continue
+		}
+		d, err := time.ParseDuration(values.Get(timeout.param))
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: %s: %w", timeout.param, err)
+		}
+		opts = append(opts, timeout.option(d))
+	}
+
+	if values.Has("x-lock-retry") {
+		retries, err := strconv.Atoi(values.Get("x-lock-retry"))
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: x-lock-retry: %w", err)
+		}
+		backoff := defaultLockBackoff
+		if values.Has("x-lock-retry-backoff") {
+			if backoff, err = time.ParseDuration(values.Get("x-lock-retry-backoff")); err != nil {
+				return "", nil, fmt.Errorf("parse dsn: x-lock-retry-backoff: %w", err)
+			}
+		}
+		opts = append(opts, WithLockRetry(retries, backoff))
+	}
+
+	if values.Has("x-watchdog") {
+		interval, err := time.ParseDuration(values.Get("x-watchdog"))
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: x-watchdog: %w", err)
+		}
+		opts = append(opts, WithWatchdog(interval))
+	}
+	if values.Has("x-watchdog-action") {
+		action, err := ParseWatchdogAction(values.Get("x-watchdog-action"))
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: x-watchdog-action: %w", err)
+		}
+		var after time.Duration
+		if values.Has("x-watchdog-after") {
+			if after, err = time.ParseDuration(values.Get("x-watchdog-after")); err != nil {
+				return "", nil, fmt.Errorf("parse dsn: x-watchdog-after: %w", err)
+			}
+		}
+		opts = append(opts, WithWatchdogAction(action, after))
+	}
+
+	if values.Has("x-pgbouncer") {
+		enabled, err := strconv.ParseBool(values.Get("x-pgbouncer"))
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: x-pgbouncer: %w", err)
+		}
+		if enabled {
+			opts = append(opts, WithPgbouncer())
+		}
+	}
+	return dsn, opts, nil
+}
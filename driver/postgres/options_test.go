@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shanna/migrate/driver"
+)
+
+func TestDSNOptions(t *testing.T) {
+	dsn, opts, err := dsnOptions("postgres://localhost/example?sslmode=disable&x-lock-retry=3&x-lock-retry-backoff=2s&x-watchdog=5s&x-watchdog-action=terminate-blockers&x-watchdog-after=1m&x-lock-timeout=1s&x-pgbouncer=true")
+	if err != nil {
+		t.Fatalf("dsn options %s", err)
+	}
+	if diff := cmp.Diff("postgres://localhost/example?sslmode=disable", dsn); diff != "" {
+		t.Errorf("dsn mismatch (-want +got):\n%s", diff)
+	}
+
+	config := &driver.Config{Logger: slog.Default()}
+	for _, opt := range opts {
+		opt(config)
+	}
+	p := newPostgres(nil, config)
+	if p.lockRetries != 3 || p.lockBackoff != 2*time.Second {
+		t.Errorf("options lock retry %d backoff %s", p.lockRetries, p.lockBackoff)
+	}
+	if p.watchdog != 5*time.Second || p.watchdogAction != WatchdogTerminateBlockers || p.watchdogAfter != time.Minute {
+		t.Errorf("options watchdog %s action %s after %s", p.watchdog, p.watchdogAction, p.watchdogAfter)
+	}
+	if !configOptions(config).pgbouncer {
+		t.Error("options pgbouncer not set")
+	}
+	if diff := cmp.Diff(map[string]string{"lock_timeout": "'1000ms'"}, p.session); diff != "" {
+		t.Errorf("session mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, bad := range []string{"x-watchdog-action=bogus", "x-watchdog=soon", "x-lock-retry=many", "x-pgbouncer=maybe", "x-lock-retry-backoff=2s", "x-watchdog-action=cancel", "x-watchdog=5s&x-watchdog-after=1m"} {
+		if _, _, err := dsnOptions("postgres://localhost/example?" + bad); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"github.com/jackc/pgx/v5"
+	"github.com/shanna/migrate/driver"
+)
+
+// WithPgbouncer makes New safe to point at pgbouncer in transaction pooling
+// mode, where each transaction may run on a different server connection. Queries
+// use the simple protocol so no named prepared statements are left behind on a
+// server connection, and the statement and description caches are turned off.
+// Also set with the x-pgbouncer DSN parameter, e.g. x-pgbouncer=true.
+//
+// The driver only ever changes settings with SET LOCAL and takes transaction
+// scoped locks, so the history table lock, WithAdvisoryLock and the session
+// settings all still hold for the single transaction a run uses. Migrations
+// that use SET, session advisory locks, LISTEN or prepared statements
+// themselves aren't safe behind a transaction pooler.
+//
+// Connections passed to NewFromConn are configured by the caller, e.g. with
+// pgx.QueryExecModeSimpleProtocol as the pool's DefaultQueryExecMode.
+func WithPgbouncer() driver.Option {
+	return option(func(o *options) {
+		o.pgbouncer = true
+	})
+}
+
+// pgbouncerConfig sets up connConfig for a transaction pooler.
+func pgbouncerConfig(connConfig *pgx.ConnConfig) {
+	connConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	connConfig.StatementCacheCapacity = 0
+	connConfig.DescriptionCacheCapacity = 0
+}
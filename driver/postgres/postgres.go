@@ -3,16 +3,17 @@ package postgres
 import (
 	"bytes"
 	"context"
-	"crypto/sha512"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
 )
 
 func init() {
@@ -27,15 +28,46 @@ type migrate struct {
 	checksum  []byte
 }
 
+// Beginner starts a transaction. *pgx.Conn, *pgxpool.Pool and pgx.Tx all
+// satisfy it. Beginning on a pgx.Tx creates a savepoint so migrations join the
+// caller's transaction.
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 type Postgres struct {
-	db        *pgx.Conn
-	tx        pgx.Tx
-	logger    driver.Logger
-	schema    string
-	tableName string
+	db           Beginner
+	conn         *pgx.Conn // Set when the driver owns the connection.
+	tx           pgx.Tx
+	logger       driver.Logger
+	schema       string
+	tableName    string
+	advisoryLock bool
+	lockWait     time.Duration
+
+	psql     bool
+	includes fs.FS
+	psqlVars map[string]string
+
+	lockRetries int
+	lockBackoff time.Duration
+
+	watchdog       time.Duration
+	watchdogAction WatchdogAction
+	watchdogAfter  time.Duration
+
+	migration     string // Name of the running migration for notices.
+	noticeHandler func(Notice)
+	session       map[string]string
 }
 
 func New(dsn string, opts ...driver.Option) (driver.Migrator, error) {
+	dsn, dsnOpts, err := dsnOptions(dsn)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(dsnOpts, opts...)
+
 	config := &driver.Config{
 		Schema:    driver.DefaultSchema,
 		TableName: driver.DefaultTableName,
@@ -52,6 +84,14 @@ func New(dsn string, opts ...driver.Option) (driver.Migrator, error) {
 
 	ctx := context.Background()
 
+	if configOptions(config).pgbouncer {
+		pgbouncerConfig(connConfig)
+	}
+
+	// Server notices such as RAISE NOTICE progress are logged as they arrive.
+	pg := newPostgres(nil, config)
+	connConfig.OnNotice = pg.onNotice
+
 	connection, err := pgx.ConnectConfig(ctx, connConfig)
 	if err != nil {
 		return nil, err
@@ -61,14 +101,76 @@ func New(dsn string, opts ...driver.Option) (driver.Migrator, error) {
 		return nil, fmt.Errorf("ping failed %s", err)
 	}
 
-	pg := &Postgres{
-		db:        connection,
-		logger:    config.Logger,
-		schema:    config.Schema,
-		tableName: config.TableName,
+	pg.db = connection
+	pg.conn = connection
+	return pg, nil
+}
+
+// NewFromConn creates a migrator using an existing connection, pool or
+// transaction. The caller keeps ownership: Commit and Rollback never close it,
+// and when conn is a pgx.Tx they release or roll back to a savepoint leaving
+// the caller to commit the outer transaction.
+func NewFromConn(conn Beginner, opts ...driver.Option) (driver.Migrator, error) {
+	if conn == nil {
+		return nil, errors.New("postgres: nil connection")
 	}
 
-	return pg, nil
+	config := &driver.Config{
+		Schema:    driver.DefaultSchema,
+		TableName: driver.DefaultTableName,
+		Logger:    slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	return newPostgres(conn, config), nil
+}
+
+func newPostgres(db Beginner, config *driver.Config) *Postgres {
+	options := configOptions(config)
+
+	return &Postgres{
+		db:           db,
+		logger:       config.Logger,
+		schema:       config.Schema,
+		tableName:    config.TableName,
+		advisoryLock: options.advisoryLock,
+		lockWait:     options.lockWait,
+
+		psql:     options.psql,
+		includes: options.includes,
+		psqlVars: options.psqlVars,
+
+		lockRetries: options.lockRetries,
+		lockBackoff: options.lockBackoff,
+
+		watchdog:       options.watchdog,
+		watchdogAction: options.watchdogAction,
+		watchdogAfter:  options.watchdogAfter,
+
+		noticeHandler: options.noticeHandler,
+		session:       options.session,
+	}
+}
+
+// ping checks an owned connection is still alive. Borrowed connections are the
+// caller's concern.
+func (p *Postgres) ping(ctx context.Context) error {
+	if p.conn == nil {
+		return nil
+	}
+	if err := p.conn.Ping(ctx); err != nil {
+		return fmt.Errorf("ping failed %s", err)
+	}
+	return nil
+}
+
+// close an owned connection.
+func (p *Postgres) close(ctx context.Context) {
+	if p.conn != nil {
+		p.conn.Close(ctx)
+	}
 }
 
 func (p *Postgres) qualifiedTableName() string {
@@ -76,7 +178,7 @@ func (p *Postgres) qualifiedTableName() string {
 }
 
 func (p *Postgres) setupSQL() string {
-	return fmt.Sprintf(`
+	sql := fmt.Sprintf(`
 create schema if not exists %s;
 
 create table if not exists %s (
@@ -85,9 +187,13 @@ create table if not exists %s (
   completed timestamp with time zone not null default now(),
   unique(name, checksum)
 );
+`, p.schema, p.qualifiedTableName())
 
-lock table %s in exclusive mode;
-`, p.schema, p.qualifiedTableName(), p.qualifiedTableName())
+	// The advisory lock is taken before setup instead.
+	if !p.advisoryLock {
+		sql += fmt.Sprintf("\nlock table %s in exclusive mode;\n", p.qualifiedTableName())
+	}
+	return sql
 }
 
 func (p *Postgres) selectMigrationSQL() string {
@@ -107,8 +213,8 @@ insert into %s (name, checksum) values ($1::text, $2::bytea)
 func (p *Postgres) Begin() error {
 	ctx := context.Background()
 
-	if err := p.db.Ping(ctx); err != nil {
-		return fmt.Errorf("ping failed %s", err)
+	if err := p.ping(ctx); err != nil {
+		return err
 	}
 
 	transaction, err := p.db.Begin(ctx)
@@ -116,6 +222,20 @@ func (p *Postgres) Begin() error {
 		return err
 	}
 
+	// With an advisory lock the lock is taken first so concurrent runs don't
+	// race to create the schema and table.
+	if p.advisoryLock {
+		if err := p.lock(ctx, transaction); err != nil {
+			transaction.Rollback(ctx)
+			return err
+		}
+	}
+
+	if err := p.setLocal(ctx, transaction, p.session); err != nil {
+		transaction.Rollback(ctx)
+		return err
+	}
+
 	// Setup creates schema/table if needed and locks the table.
 	// The lock serializes concurrent migrations.
 	if _, err := transaction.Exec(ctx, p.setupSQL()); err != nil {
@@ -130,66 +250,165 @@ func (p *Postgres) Begin() error {
 func (p *Postgres) Rollback() error {
 	ctx := context.Background()
 
-	defer p.db.Close(ctx)
+	defer p.close(ctx)
 	return p.tx.Rollback(ctx)
 }
 
 func (p *Postgres) Commit() error {
 	ctx := context.Background()
 
-	defer p.db.Close(ctx)
+	defer p.close(ctx)
 	return p.tx.Commit(ctx)
 }
 
 func (p *Postgres) Migrate(name string, data io.Reader) error {
 	ctx := context.Background()
 
-	if err := p.db.Ping(ctx); err != nil {
-		return fmt.Errorf("ping failed %s", err)
+	if err := p.ping(ctx); err != nil {
+		return err
 	}
 
-	// Shame you can't stream statements to the driver as well.
-	checksum := sha512.New()
-	reader := io.TeeReader(data, checksum)
-	statements, err := io.ReadAll(reader)
-	if err != nil {
-		p.tx.Rollback(ctx)
-		return fmt.Errorf("read %s", err)
+	if p.lockRetries > 0 {
+		return p.retry(ctx, name, data)
 	}
+	return p.migrate(ctx, name, data)
+}
+
+// migrate runs and records name unless it has already run.
+func (p *Postgres) migrate(ctx context.Context, name string, data io.Reader) error {
+	dialect := statement.Postgres
+	if p.psql {
+		dialect = statement.Psql
+	}
+	stream := driver.NewStream(data, dialect)
+
+	skip, err := p.skip(ctx, name, stream.Checksum)
+	if err != nil || skip {
+		return err
+	}
+
+	p.migration = name
+	defer func() { p.migration = "" }()
+	defer p.watch(ctx, name)()
+
+	vars := make(map[string]string, len(p.psqlVars))
+	for k, v := range p.psqlVars {
+		vars[k] = v
+	}
+	s := &script{file: name, vars: vars}
+	if err := p.run(ctx, s, stream.Scanner); err != nil {
+		return err
+	}
+
+	// Put back the run's settings after a file overrode them.
+	if len(s.overrides) > 0 {
+		restore := map[string]string{}
+		for name := range s.overrides {
+			restore[name] = p.session[name]
+		}
+		if err := p.setLocal(ctx, p.tx, restore); err != nil {
+			return err
+		}
+	}
+
+	return p.record(ctx, name, stream.Checksum)
+}
+
+// run executes statements as they are read so large migrations are never held
+// in memory as a whole. COPY ... FROM STDIN data, as in pg_dump output, is
+// streamed through the copy protocol.
+func (p *Postgres) run(ctx context.Context, s *script, scanner *statement.Scanner) error {
+	for scanner.Scan() {
+		stmt := scanner.Statement()
+		sql := stmt.SQL
+		if p.psql {
+			sql = interpolate(sql, s.vars)
+		}
 
+		// Directives heading a migration file override the run's settings.
+		if s.depth == 0 && stmt.Index == 0 {
+			if s.overrides = directives(stmt.SQL); len(s.overrides) > 0 {
+				if err := p.setLocal(ctx, p.tx, s.overrides); err != nil {
+					return err
+				}
+			}
+		}
+
+		var err error
+		switch {
+		case stmt.Meta != "":
+			err = p.meta(ctx, s, stmt)
+		case stmt.Copy:
+			_, err = p.tx.Conn().PgConn().CopyFrom(ctx, scanner.CopyData(), sql)
+		default:
+			_, err = p.tx.Exec(ctx, sql)
+		}
+		if err != nil {
+			// Already located and logged by an included file.
+			var located *Error
+			if errors.As(err, &located) {
+				return err
+			}
+
+			var pgErr *pgconn.PgError
+			if !errors.As(err, &pgErr) {
+				p.logger.Error(fmt.Sprintf("migrate error %s", s.file), "driver", "postgres", "error", err, "statement", stmt.Index+1, "line", stmt.Line, "sql", truncate(stmt.SQL))
+				return err
+			}
+
+			// Queries run by a meta-command such as \gexec aren't the
+			// statement's text so can't be positioned in it.
+			if stmt.Meta != "" {
+				sql = ""
+			}
+			located = newError(s.file, stmt, sql, pgErr)
+			p.logger.Error(fmt.Sprintf("migrate error %s", s.file), "driver", "postgres", "error", pgErr, "code", pgErr.Code, "statement", stmt.Index+1, "line", located.Line, "column", located.Column, "sql", truncate(stmt.SQL))
+			return located
+		}
+	}
+	return scanner.Err()
+}
+
+// skip reports whether name has already run, failing if it has been altered
+// since.
+func (p *Postgres) skip(ctx context.Context, name string, checksum func() ([]byte, error)) (bool, error) {
 	rows, err := p.tx.Query(ctx, p.selectMigrationSQL(), name)
 	if err != nil {
-		return fmt.Errorf("schema_migrations select previous %s", err)
+		return false, fmt.Errorf("schema_migrations select previous %s", err)
 	}
 	defer rows.Close()
 
-	if rows.Next() {
-		previous := migrate{}
-		err = rows.Scan(&previous.name, &previous.completed, &previous.checksum)
-		if err != nil {
-			return fmt.Errorf("schema_migrations scan previous %s", err)
-		}
-		if !bytes.Equal(checksum.Sum(nil), previous.checksum) {
-			return fmt.Errorf("%q has been altered since it was run on %s", previous.name, previous.completed)
-		}
+	if !rows.Next() {
+		return false, nil
+	}
 
-		p.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "postgres", "completed", previous.completed)
-		return nil
+	previous := migrate{}
+	err = rows.Scan(&previous.name, &previous.completed, &previous.checksum)
+	if err != nil {
+		return false, fmt.Errorf("schema_migrations scan previous %s", err)
 	}
 	rows.Close()
 
-	if _, err := p.tx.Exec(ctx, string(statements)); err != nil {
-		var pgErr *pgconn.PgError
-		// if perr, ok := err.(*pgconn.PgError); ok {
-		if errors.As(err, &pgErr) {
-			p.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "postgres", "error", err, "code", pgErr.Code, "line", pgErr.Line, "sql", string(statements))
-		} else {
-			p.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "postgres", "error", err, "sql", string(statements))
-		}
-		return err
+	sum, err := checksum()
+	if err != nil {
+		return false, fmt.Errorf("read %s", err)
+	}
+	if !bytes.Equal(sum, previous.checksum) {
+		return false, fmt.Errorf("%q has been altered since it was run on %s", previous.name, previous.completed)
+	}
+
+	p.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "postgres", "completed", previous.completed)
+	return true, nil
+}
+
+// record name as run in the history table.
+func (p *Postgres) record(ctx context.Context, name string, checksum func() ([]byte, error)) error {
+	sum, err := checksum()
+	if err != nil {
+		return fmt.Errorf("read %s", err)
 	}
 
-	if _, err = p.tx.Exec(ctx, p.insertMigrationSQL(), name, checksum.Sum(nil)); err != nil {
+	if _, err = p.tx.Exec(ctx, p.insertMigrationSQL(), name, sum); err != nil {
 		return fmt.Errorf("schema_migrations insert %s", err)
 	}
 
@@ -1,15 +1,23 @@
 package postgres_test
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgx/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/ory/dockertest"
+	mdriver "github.com/shanna/migrate/driver"
 	driver "github.com/shanna/migrate/driver/postgres"
 )
 
@@ -128,3 +136,493 @@ func TestPostgresMigrateRollback(t *testing.T) {
 		t.Fatalf("expected table to not exist after rollback")
 	}
 }
+
+func TestPostgresAdvisoryLock(t *testing.T) {
+	first, err := driver.New(config, driver.WithAdvisoryLock(0))
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+
+	if err = first.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	db, err := sql.Open("pgx", config)
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	defer db.Close()
+
+	// Unlike the table lock, readers of the history table aren't blocked.
+	var count int
+	if err := db.QueryRow(`select count(*) from migrate.schema_migrations`).Scan(&count); err != nil {
+		t.Fatalf("select history while locked %s", err)
+	}
+
+	second, err := driver.New(config, driver.WithAdvisoryLock(500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("postgres connect %s", err)
+	}
+
+	err = second.Begin()
+	var lockErr *driver.LockError
+	if !errors.Is(err, driver.ErrLocked) || !errors.As(err, &lockErr) {
+		t.Fatalf("expected lock error, got %v", err)
+	}
+	if lockErr.PID == 0 {
+		t.Errorf("expected lock holder pid, got %v", lockErr)
+	}
+
+	if err = first.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	third, err := driver.New(config, driver.WithAdvisoryLock(0))
+	if err != nil {
+		t.Fatalf("postgres connect %s", err)
+	}
+	if err = third.Begin(); err != nil {
+		t.Fatalf("begin after release %s", err)
+	}
+	if err = third.Rollback(); err != nil {
+		t.Fatalf("rollback %s", err)
+	}
+}
+
+func TestPostgresNewFromConnTx(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := pgx.Connect(ctx, config)
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin caller transaction %s", err)
+	}
+
+	migrator, err := driver.NewFromConn(tx)
+	if err != nil {
+		t.Fatalf("new from conn %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate(`migrate from tx`, strings.NewReader(`create table from_tx_test (id text)`)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	// Visible inside the caller's transaction.
+	if _, err = tx.Exec(ctx, `select id from from_tx_test`); err != nil {
+		t.Fatalf("select inside transaction %s", err)
+	}
+
+	// Gone once the caller rolls back, and the connection is still usable.
+	if err = tx.Rollback(ctx); err != nil {
+		t.Fatalf("rollback caller transaction %s", err)
+	}
+	if _, err = conn.Exec(ctx, `select id from from_tx_test`); err == nil {
+		t.Fatalf("expected table to not exist after caller rollback")
+	}
+}
+
+func TestPostgresLoad(t *testing.T) {
+	migrator, err := driver.New(config)
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	schema := `create table load_test (code text primary key, name text, population bigint not null default 0)`
+	if err = migrator.Migrate("load: schema", strings.NewReader(schema)); err != nil {
+		t.Fatalf("schema %s", err)
+	}
+
+	loader := migrator.(mdriver.Loader)
+	csv := "name,code,population\n\"New\nZealand\",NZ,5000000\n"
+	if err = loader.Load("load: csv", strings.NewReader(csv), mdriver.Data{Table: "load_test", Format: mdriver.CSV}); err != nil {
+		t.Fatalf("load csv %s", err)
+	}
+	ndjson := `{"code": "AU", "name": "Australia"}` + "\n" + `{"code": "FR", "name": "France", "population": 68000000}` + "\n"
+	if err = loader.Load("load: ndjson", strings.NewReader(ndjson), mdriver.Data{Table: "load_test", Format: mdriver.NDJSON}); err != nil {
+		t.Fatalf("load ndjson %s", err)
+	}
+	if err = loader.Load("load: parquet", strings.NewReader(""), mdriver.Data{Table: "load_test", Format: mdriver.Parquet}); !errors.Is(err, mdriver.ErrUnsupported) {
+		t.Errorf("expected unsupported parquet, got %v", err)
+	}
+
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("pgx", config)
+	if err != nil {
+		t.Fatalf("post migrate connect %s", err)
+	}
+	defer db.Close()
+
+	var got string
+	err = db.QueryRow(`select string_agg(code || ':' || population, ',' order by code) from load_test`).Scan(&got)
+	if err != nil || got != "AU:0,FR:68000000,NZ:5000000" {
+		t.Errorf("expected loaded rows, got %q %v", got, err)
+	}
+}
+
+func TestPostgresCopy(t *testing.T) {
+	migrator, err := driver.New(config)
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+
+	dump := "create table copy_test (id integer primary key, bio text);\n\n" +
+		"--\n-- Data for Name: copy_test\n--\n\n" +
+		"COPY public.copy_test (id, bio) FROM stdin;\n" +
+		"1\tlikes; semicolons\n" +
+		"2\t\\N\n" +
+		"\\.\n\n" +
+		"update copy_test set bio = 'none' where bio is null;\n"
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate("migrate copy: dump", strings.NewReader(dump)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("pgx", config)
+	if err != nil {
+		t.Fatalf("post migrate connect %s", err)
+	}
+	defer db.Close()
+
+	var got string
+	err = db.QueryRow(`select string_agg(id || ':' || bio, ',' order by id) from copy_test`).Scan(&got)
+	if err != nil || got != "1:likes; semicolons,2:none" {
+		t.Errorf("expected copied rows, got %q %v", got, err)
+	}
+}
+
+func TestPostgresPsql(t *testing.T) {
+	includes := fstest.MapFS{
+		"lib/tables.sql":  {Data: []byte("\\ir columns.sql\ncreate table :\"prefix\"_b (id integer);\n")},
+		"lib/columns.sql": {Data: []byte("create table :\"prefix\"_a (note text default :'note');\n")},
+	}
+	migrator, err := driver.New(config, driver.WithPsql(includes), driver.WithPsqlVar("note", "it's"))
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+
+	script := "\\set ON_ERROR_STOP on\n" +
+		"\\set prefix psql_test\n" +
+		"\\echo 'creating' :prefix tables\n" +
+		"\\i lib/tables.sql\n" +
+		"select format('insert into %I (id) values (1)', tablename) from pg_tables where tablename = 'psql_test_b' \\gexec\n"
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate("psql.sql", strings.NewReader(script)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("pgx", config)
+	if err != nil {
+		t.Fatalf("post migrate connect %s", err)
+	}
+	defer db.Close()
+
+	var note string
+	if err := db.QueryRow(`insert into psql_test_a default values returning note`).Scan(&note); err != nil || note != "it's" {
+		t.Errorf("expected interpolated default, got %q %v", note, err)
+	}
+	var count int
+	if err := db.QueryRow(`select count(*) from psql_test_b`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("expected gexec insert, got %d %v", count, err)
+	}
+
+	migrator, err = driver.New(config, driver.WithPsql(includes))
+	if err != nil {
+		t.Fatalf("postgres connect %s", err)
+	}
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	defer migrator.Rollback()
+	if err = migrator.Migrate("psql unsupported.sql", strings.NewReader("\\copy psql_test_a from 'a.csv'\n")); err == nil {
+		t.Errorf("expected unsupported meta-command error")
+	}
+}
+
+func TestPostgresNotice(t *testing.T) {
+	var notices []driver.Notice
+	migrator, err := driver.New(config, driver.WithNoticeHandler(func(n driver.Notice) {
+		if n.Migration != "" {
+			notices = append(notices, n)
+		}
+	}))
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+
+	progress := `do $$ begin
+  raise notice 'processed % rows', 10;
+  raise warning 'slow batch' using errcode = '01000', hint = 'add an index';
+end $$`
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate("migrate notice: progress", strings.NewReader(progress)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	want := []driver.Notice{
+		{Migration: "migrate notice: progress", Severity: "NOTICE", Code: "00000", Message: "processed 10 rows"},
+		{Migration: "migrate notice: progress", Severity: "WARNING", Code: "01000", Message: "slow batch", Hint: "add an index"},
+	}
+	if diff := cmp.Diff(want, notices); diff != "" {
+		t.Errorf("notices mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPostgresSession(t *testing.T) {
+	migrator, err := driver.New(config, driver.WithLockTimeout(3*time.Second), driver.WithSearchPath("public"))
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+
+	settings := "select current_setting('lock_timeout'), current_setting('statement_timeout'), current_setting('search_path')"
+	migrations := []struct {
+		name string
+		sql  string
+	}{
+		{"migrate session: 1", "create table session_test (lock_timeout text, statement_timeout text, search_path text);\n" +
+			"insert into session_test " + settings},
+		{"migrate session: 2", "-- migrate:lock_timeout 1s\n-- migrate:statement_timeout 1min\ninsert into session_test " + settings},
+		{"migrate session: 3", "insert into session_test " + settings},
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	for _, migration := range migrations {
+		if err = migrator.Migrate(migration.name, strings.NewReader(migration.sql)); err != nil {
+			t.Fatalf("migrate %s", err)
+		}
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("pgx", config)
+	if err != nil {
+		t.Fatalf("post migrate connect %s", err)
+	}
+	defer db.Close()
+
+	var got []string
+	rows, err := db.Query(`select lock_timeout || ' ' || statement_timeout || ' ' || search_path from session_test`)
+	if err != nil {
+		t.Fatalf("select %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			t.Fatalf("scan %s", err)
+		}
+		got = append(got, row)
+	}
+
+	want := []string{"3s 0 public", "1s 1min public", "3s 0 public"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("settings mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPostgresLockRetry(t *testing.T) {
+	db, err := sql.Open("pgx", config)
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`create table lock_retry_test (id integer)`); err != nil {
+		t.Fatalf("create %s", err)
+	}
+
+	// Hold a lock on the table for a while from another session.
+	holder, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin holder %s", err)
+	}
+	if _, err := holder.Exec(`lock table lock_retry_test in access exclusive mode`); err != nil {
+		t.Fatalf("lock %s", err)
+	}
+	released := time.AfterFunc(time.Second, func() { holder.Rollback() })
+	defer released.Stop()
+
+	migrator, err := driver.New(config, driver.WithLockTimeout(100*time.Millisecond), driver.WithLockRetry(10, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("postgres connect %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate("migrate lock retry: 1", strings.NewReader(`alter table lock_retry_test add column name text`)); err != nil {
+		migrator.Rollback()
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	var name sql.NullString
+	if err := db.QueryRow(`select name from lock_retry_test`).Scan(&name); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected name column, got %s", err)
+	}
+}
+
+func TestPostgresWatchdog(t *testing.T) {
+	db, err := sql.Open("pgx", config)
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`create table watchdog_test (id integer)`); err != nil {
+		t.Fatalf("create %s", err)
+	}
+
+	// An idle transaction holding a lock the migration needs.
+	holder, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin holder %s", err)
+	}
+	defer holder.Rollback()
+	if _, err := holder.Exec(`select * from watchdog_test`); err != nil {
+		t.Fatalf("lock %s", err)
+	}
+
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	migrator, err := driver.New(config,
+		mdriver.WithLogger(logger),
+		driver.WithWatchdog(100*time.Millisecond),
+		driver.WithWatchdogAction(driver.WatchdogTerminateBlockers, 300*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("postgres connect %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate("migrate watchdog: 1", strings.NewReader(`alter table watchdog_test add column name text`)); err != nil {
+		migrator.Rollback()
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	for _, want := range []string{`msg="migrate blocked migrate watchdog: 1"`, `blocker_query="select * from watchdog_test"`, `action=terminate-blockers`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected %s in logs:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestPostgresPgbouncer(t *testing.T) {
+	opts := []mdriver.Option{driver.WithAdvisoryLock(0)}
+
+	first, err := driver.New(config+"&x-pgbouncer=true", opts...)
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+	second, err := driver.New(config+"&x-pgbouncer=true", opts...)
+	if err != nil {
+		t.Fatalf("postgres connect %s", err)
+	}
+
+	// Nothing is prepared on the server connection, which behind pgbouncer may
+	// be handed to another client after the transaction.
+	prepared := "create table pgbouncer_test (prepared bigint);\n" +
+		"insert into pgbouncer_test select count(*) from pg_prepared_statements;\n"
+
+	if err = first.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = first.Migrate("migrate pgbouncer: 1", strings.NewReader(prepared)); err != nil {
+		first.Rollback()
+		t.Fatalf("migrate %s", err)
+	}
+
+	// The advisory lock is transaction scoped so still serialises runs.
+	if err = second.Begin(); !errors.Is(err, driver.ErrLocked) {
+		t.Errorf("expected ErrLocked while the first run is in progress, got %v", err)
+	}
+
+	if err = first.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("pgx", config)
+	if err != nil {
+		t.Fatalf("post migrate connect %s", err)
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow(`select prepared from pgbouncer_test`).Scan(&count); err != nil || count != 0 {
+		t.Errorf("expected no prepared statements, got %d %v", count, err)
+	}
+}
+
+func TestPostgresErrorPosition(t *testing.T) {
+	migrator, err := driver.New(config)
+	if err != nil {
+		t.Skipf("postgres connect %s", err)
+	}
+
+	migration := "create table error_test (id integer, email text);\n\n" +
+		"select id,\n  emial from error_test;\n"
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	defer migrator.Rollback()
+
+	err = migrator.Migrate("0001_error.sql", strings.NewReader(migration))
+
+	var located *driver.Error
+	if !errors.As(err, &located) {
+		t.Fatalf("expected *postgres.Error, got %T %v", err, err)
+	}
+	if located.File != "0001_error.sql" || located.Statement != 2 || located.Line != 4 || located.Column != 3 || located.Code != "42703" {
+		t.Errorf("expected 0001_error.sql statement 2 at 4:3 SQLSTATE 42703, got %s statement %d at %d:%d SQLSTATE %s",
+			located.File, located.Statement, located.Line, located.Column, located.Code)
+	}
+	if !strings.Contains(err.Error(), "  emial from error_test\n      ^") {
+		t.Errorf("expected caret under the column, got:\n%s", err)
+	}
+}
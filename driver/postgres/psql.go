@@ -0,0 +1,326 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
+)
+
+// maxIncludeDepth guards against includes that include themselves.
+const maxIncludeDepth = 16
+
+// WithPsql runs migrations in psql compatibility mode, handling a subset of
+// psql meta-commands rather than sending them to the server:
+//
+//	\set name value       set a variable, values are concatenated
+//	\unset name           remove a variable
+//	\echo text            log text at info level
+//	\i file, \ir file     run file read from fsys (also \include and
+//	                      \include_relative), relative to the including file
+//	query \gexec          run query then each value it returns as a statement
+//
+// Variables are interpolated psql style as :name, :'name' quoted as a literal
+// and :"name" quoted as an identifier, outside of strings and comments.
+// Unknown variables are left as written. Any other meta-command fails the
+// migration.
+//
+// Migration names are resolved in fsys, so with the default name func fsys is
+// the migration directory, e.g. os.DirFS("migrations"). Included files aren't
+// part of the migration's checksum. A nil fsys rejects includes. Also set with
+// the x-psql=dir DSN parameter.
+func WithPsql(fsys fs.FS) driver.Option {
+	return option(func(o *options) {
+		o.psql = true
+		o.includes = fsys
+	})
+}
+
+// WithPsqlVar sets a variable at the start of every migration run in psql
+// mode, like psql -v name=value. Also set with one or more
+// x-psql-var=name=value DSN parameters.
+func WithPsqlVar(name, value string) driver.Option {
+	return option(func(o *options) {
+		if o.psqlVars == nil {
+			o.psqlVars = map[string]string{}
+		}
+		o.psqlVars[name] = value
+	})
+}
+
+// script is a migration file, or a file it includes, being run.
+type script struct {
+	file      string
+	vars      map[string]string
+	depth     int
+	overrides map[string]string // Session settings from directives.
+}
+
+// meta runs a psql meta-command.
+func (p *Postgres) meta(ctx context.Context, s *script, stmt statement.Statement) error {
+	command, rest, _ := strings.Cut(stmt.Meta, " ")
+	if stmt.SQL != "" && command != `\gexec` {
+		return fmt.Errorf("psql %s must follow a complete statement", command)
+	}
+
+	args, err := metaArgs(rest, s.vars)
+	if err != nil {
+		return fmt.Errorf("psql %s: %w", command, err)
+	}
+
+	switch command {
+	case `\set`:
+		if len(args) == 0 {
+			return fmt.Errorf("psql %s: missing variable name", command)
+		}
+		s.vars[args[0]] = strings.Join(args[1:], "")
+	case `\unset`:
+		if len(args) != 1 {
+			return fmt.Errorf("psql %s: expected a variable name", command)
+		}
+		delete(s.vars, args[0])
+	case `\echo`:
+		p.logger.Info(strings.Join(args, " "), "driver", "postgres", "file", s.file)
+	case `\i`, `\include`, `\ir`, `\include_relative`:
+		if len(args) != 1 {
+			return fmt.Errorf("psql %s: expected a file name", command)
+		}
+		return p.include(ctx, s, args[0])
+	case `\gexec`:
+		if stmt.SQL == "" {
+			return fmt.Errorf("psql %s: no query", command)
+		}
+		return p.gexec(ctx, interpolate(stmt.SQL, s.vars))
+	default:
+		return fmt.Errorf("psql meta-command %s is not supported", command)
+	}
+	return nil
+}
+
+// include runs file relative to the including script.
+func (p *Postgres) include(ctx context.Context, s *script, file string) error {
+	if p.includes == nil {
+		return fmt.Errorf("psql include %s: no include directory configured", file)
+	}
+	if path.IsAbs(file) {
+		return fmt.Errorf("psql include %s: must be relative", file)
+	}
+	if s.depth >= maxIncludeDepth {
+		return fmt.Errorf("psql include %s: nested more than %d deep", file, maxIncludeDepth)
+	}
+
+	included := path.Join(path.Dir(s.file), file)
+	fh, err := p.includes.Open(included)
+	if err != nil {
+		return fmt.Errorf("psql include: %w", err)
+	}
+	defer fh.Close()
+
+	return p.run(ctx, &script{file: included, vars: s.vars, depth: s.depth + 1}, statement.NewScanner(fh, statement.Psql))
+}
+
+// gexec runs query then each non-null value it returns as a statement.
+func (p *Postgres) gexec(ctx context.Context, query string) error {
+	rows, err := p.tx.Query(ctx, query, pgx.QueryExecModeSimpleProtocol)
+	if err != nil {
+		return err
+	}
+	var statements []string
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		for _, value := range values {
+			if value != nil {
+				statements = append(statements, fmt.Sprint(value))
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, sql := range statements {
+		if _, err := p.tx.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("gexec %q: %w", sql, err)
+		}
+	}
+	return nil
+}
+
+// metaArgs splits meta-command arguments psql style. Single quoted arguments
+// may hold spaces, with a doubled single quote for a quote, and arguments are
+// interpolated.
+func metaArgs(rest string, vars map[string]string) ([]string, error) {
+	var args []string
+	for rest = strings.TrimLeft(rest, " \t"); rest != ""; rest = strings.TrimLeft(rest, " \t") {
+		var arg strings.Builder
+		for rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			switch rest[0] {
+			case '\'':
+				end := 1
+				for {
+					i := strings.IndexByte(rest[end:], '\'')
+					if i < 0 {
+						return nil, errors.New("unterminated quoted string")
+					}
+					end += i + 1
+					if end < len(rest) && rest[end] == '\'' {
+						end++
+						continue
+					}
+					break
+				}
+				arg.WriteString(strings.ReplaceAll(rest[1:end-1], "''", "'"))
+				rest = rest[end:]
+			case '`':
+				return nil, errors.New("backtick shell commands are not supported")
+			default:
+				n := strings.IndexAny(rest, " \t'`")
+				if n < 0 {
+					n = len(rest)
+				}
+				arg.WriteString(interpolate(rest[:n], vars))
+				rest = rest[n:]
+			}
+		}
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// interpolate replaces :name, :'name' and :"name" with variables outside of
+// strings, quoted identifiers, dollar quoted bodies and comments.
+func interpolate(sql string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(sql, ":") {
+		return sql
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := quoted(sql, i, c)
+			out.WriteString(sql[i:end])
+			i = end
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			out.WriteString(sql[i : i+end])
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql) - i - 4
+			}
+			out.WriteString(sql[i : i+end+4])
+			i += end + 4
+		case c == '$':
+			end := dollarQuoted(sql, i)
+			out.WriteString(sql[i:end])
+			i = end
+		case c == ':' && strings.HasPrefix(sql[i:], "::"):
+			out.WriteString("::")
+			i += 2
+		case c == ':':
+			value, n := variable(sql[i+1:], vars)
+			if n == 0 {
+				out.WriteByte(c)
+				i++
+				continue
+			}
+			out.WriteString(value)
+			i += 1 + n
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+// variable returns the value of the reference starting s and its length, or a
+// zero length when s isn't a reference to a set variable.
+func variable(s string, vars map[string]string) (string, int) {
+	quote := byte(0)
+	if s != "" && (s[0] == '\'' || s[0] == '"') {
+		quote = s[0]
+		s = s[1:]
+	}
+
+	n := 0
+	for n < len(s) && isNameByte(s[n]) {
+		n++
+	}
+	if n == 0 {
+		return "", 0
+	}
+	value, ok := vars[s[:n]]
+	if !ok {
+		return "", 0
+	}
+
+	switch quote {
+	case '\'':
+		if n == len(s) || s[n] != '\'' {
+			return "", 0
+		}
+		return `'` + strings.ReplaceAll(value, `'`, `''`) + `'`, n + 2
+	case '"':
+		if n == len(s) || s[n] != '"' {
+			return "", 0
+		}
+		return pgx.Identifier{value}.Sanitize(), n + 2
+	}
+	return value, n
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// quoted returns the end of the string or identifier opened at start.
+func quoted(sql string, start int, quote byte) int {
+	escapes := quote == '\'' && start > 0 && (sql[start-1] == 'e' || sql[start-1] == 'E') && (start == 1 || !isNameByte(sql[start-2]))
+	for i := start + 1; i < len(sql); i++ {
+		switch {
+		case escapes && sql[i] == '\\':
+			i++
+		case sql[i] == quote && i+1 < len(sql) && sql[i+1] == quote:
+			i++
+		case sql[i] == quote:
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// dollarQuoted returns the end of the $tag$ body opened at start, or just
+// past the dollar when it is something else such as $1.
+func dollarQuoted(sql string, start int) int {
+	n := start + 1
+	for n < len(sql) && isNameByte(sql[n]) && !(n == start+1 && sql[n] >= '0' && sql[n] <= '9') {
+		n++
+	}
+	if n >= len(sql) || sql[n] != '$' {
+		return start + 1
+	}
+	tag := sql[start : n+1]
+	end := strings.Index(sql[n+1:], tag)
+	if end < 0 {
+		return len(sql)
+	}
+	return n + 1 + end + len(tag)
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{"schema": "app", "owner": "o'brien", "table": "User Table"}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"create schema :schema", "create schema app"},
+		{"comment on schema :schema is :'owner'", "comment on schema app is 'o''brien'"},
+		{"select * from :\"table\"", `select * from "User Table"`},
+		{"select 1::int, :missing, :'missing'", "select 1::int, :missing, :'missing'"},
+		{"select ':schema', \":schema\" -- :schema\n, :schema", "select ':schema', \":schema\" -- :schema\n, app"},
+		{"select $$:schema$$, $1, $f$ :schema $f$ /* :schema */", "select $$:schema$$, $1, $f$ :schema $f$ /* :schema */"},
+		{`select E'\':schema', :schema`, `select E'\':schema', app`},
+	}
+
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, interpolate(tt.sql, vars)); diff != "" {
+			t.Errorf("interpolate %q mismatch (-want +got):\n%s", tt.sql, diff)
+		}
+	}
+}
+
+func TestMetaArgs(t *testing.T) {
+	vars := map[string]string{"name": "world"}
+
+	tests := []struct {
+		rest string
+		want []string
+		err  bool
+	}{
+		{"greeting hello", []string{"greeting", "hello"}, false},
+		{"  'hello :name'  :name ", []string{"hello :name", "world"}, false},
+		{"'it''s' x'y'z", []string{"it's", "xyz"}, false},
+		{"'unterminated", nil, true},
+		{"`date`", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := metaArgs(tt.rest, vars)
+		if (err != nil) != tt.err {
+			t.Errorf("meta args %q expected error %t, got %v", tt.rest, tt.err, err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("meta args %q mismatch (-want +got):\n%s", tt.rest, diff)
+		}
+	}
+}
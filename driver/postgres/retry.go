@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
+)
+
+// lockNotAvailable is the SQLSTATE raised when lock_timeout expires or a
+// NOWAIT lock can't be taken.
+const lockNotAvailable = "55P03"
+
+// defaultLockBackoff is the first delay for x-lock-retry without
+// x-lock-retry-backoff.
+const defaultLockBackoff = 500 * time.Millisecond
+
+// maxRetryBackoff caps the delay between attempts however many there are.
+const maxRetryBackoff = time.Minute
+
+// WithLockRetry runs each migration in a savepoint and retries it up to
+// retries times when it fails waiting on a lock, SQLSTATE 55P03. The first
+// retry waits around backoff, doubling each time with jitter. Pair it with
+// WithLockTimeout so DDL on a busy table gives up quickly rather than queueing
+// every other query behind it. Also set with the x-lock-retry and
+// x-lock-retry-backoff DSN parameters, e.g. x-lock-retry=5&x-lock-retry-backoff=1s.
+//
+// Migrations are replayed from the start on each attempt so are spooled to a
+// temporary file as the first attempt reads them.
+func WithLockRetry(retries int, backoff time.Duration) driver.Option {
+	return option(func(o *options) {
+		o.lockRetries = retries
+		o.lockBackoff = backoff
+	})
+}
+
+// isLockNotAvailable reports whether err is a lock timeout.
+func isLockNotAvailable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == lockNotAvailable
+}
+
+// retryDelay is the backoff doubled for each earlier attempt, capped, then
+// jittered between half and all of it so concurrent runs spread out.
+func (p *Postgres) retryDelay(attempt int) time.Duration {
+	delay := maxRetryBackoff
+	if shift := attempt - 1; shift < 32 && p.lockBackoff<<shift < maxRetryBackoff {
+		delay = p.lockBackoff << shift
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// retry migrate inside a savepoint, rolling back to it and trying again after
+// a delay each time it fails on a lock timeout.
+func (p *Postgres) retry(ctx context.Context, name string, data io.Reader) error {
+	// Nothing is spooled for a migration that has already run.
+	skip, err := p.skip(ctx, name, driver.NewStream(data, statement.Postgres).Checksum)
+	if err != nil || skip {
+		return err
+	}
+
+	spool, err := os.CreateTemp("", "migrate-retry-*")
+	if err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	// The first attempt reads the migration as it is spooled, later attempts
+	// replay the spool.
+	source := io.TeeReader(data, spool)
+
+	tx := p.tx
+	defer func() { p.tx = tx }()
+
+	for attempt := 1; ; attempt++ {
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("savepoint: %w", err)
+		}
+
+		p.tx = savepoint
+		err = p.migrate(ctx, name, source)
+		p.tx = tx
+		if err == nil {
+			return savepoint.Commit(ctx)
+		}
+
+		if rollbackErr := savepoint.Rollback(ctx); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback to savepoint: %w", rollbackErr))
+		}
+		if !isLockNotAvailable(err) || attempt > p.lockRetries {
+			return err
+		}
+
+		// Spool whatever the failed attempt left unread then rewind.
+		if _, err := io.Copy(io.Discard, source); err != nil {
+			return fmt.Errorf("spool: %w", err)
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("spool: %w", err)
+		}
+		source = spool
+
+		delay := p.retryDelay(attempt)
+		p.warn(fmt.Sprintf("migrate retry %s", name), "driver", "postgres", "attempt", attempt, "retries", p.lockRetries, "delay", delay, "error", err)
+		time.Sleep(delay)
+	}
+}
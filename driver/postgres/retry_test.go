@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := &Postgres{lockBackoff: time.Second}
+
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{4, 4 * time.Second, 8 * time.Second},
+		{7, maxRetryBackoff / 2, maxRetryBackoff},
+		{100, maxRetryBackoff / 2, maxRetryBackoff},
+	}
+
+	for _, tt := range tests {
+		for range 100 {
+			if got := p.retryDelay(tt.attempt); got < tt.min || got > tt.max {
+				t.Fatalf("retryDelay(%d) = %s, want between %s and %s", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
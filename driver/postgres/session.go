@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shanna/migrate/driver"
+)
+
+// sessionSettings are applied with SET LOCAL in this order, so the role is
+// assumed before anything else.
+var sessionSettings = []string{"role", "search_path", "lock_timeout", "statement_timeout", "idle_in_transaction_session_timeout"}
+
+// WithRole runs migrations as role with SET LOCAL ROLE so the objects they
+// create are owned by it rather than the user logged in. The history table is
+// written as role too. Also set with the x-role DSN parameter.
+func WithRole(role string) driver.Option {
+	return sessionOption("role", pgx.Identifier{role}.Sanitize())
+}
+
+// WithSearchPath sets search_path for the run. Also set with the
+// x-search-path DSN parameter holding a comma separated list.
+func WithSearchPath(schemas ...string) driver.Option {
+	return sessionOption("search_path", searchPath(schemas))
+}
+
+// WithLockTimeout sets lock_timeout for the run so a migration waiting on a
+// busy table fails instead of queueing every query behind it. Also set with
+// the x-lock-timeout DSN parameter, e.g. x-lock-timeout=5s.
+func WithLockTimeout(timeout time.Duration) driver.Option {
+	return sessionOption("lock_timeout", milliseconds(timeout))
+}
+
+// WithStatementTimeout sets statement_timeout for the run. Also set with the
+// x-statement-timeout DSN parameter.
+func WithStatementTimeout(timeout time.Duration) driver.Option {
+	return sessionOption("statement_timeout", milliseconds(timeout))
+}
+
+// WithIdleInTransactionTimeout sets idle_in_transaction_session_timeout for
+// the run. Also set with the x-idle-in-transaction-timeout DSN parameter.
+func WithIdleInTransactionTimeout(timeout time.Duration) driver.Option {
+	return sessionOption("idle_in_transaction_session_timeout", milliseconds(timeout))
+}
+
+// sessionOption sets name to an SQL value applied with SET LOCAL inside the
+// driver's transaction. Joined to a caller's transaction with NewFromConn the
+// settings last until that transaction ends.
+func sessionOption(name, value string) driver.Option {
+	return option(func(o *options) {
+		if o.session == nil {
+			o.session = map[string]string{}
+		}
+		o.session[name] = value
+	})
+}
+
+func milliseconds(d time.Duration) string {
+	return fmt.Sprintf("'%dms'", d.Milliseconds())
+}
+
+func literal(value string) string {
+	return `'` + strings.ReplaceAll(value, `'`, `''`) + `'`
+}
+
+func searchPath(schemas []string) string {
+	quoted := make([]string, len(schemas))
+	for i, schema := range schemas {
+		quoted[i] = pgx.Identifier{strings.Trim(strings.TrimSpace(schema), `"`)}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// setLocal applies settings with SET LOCAL, or resets those with an empty
+// value to the session default.
+func (p *Postgres) setLocal(ctx context.Context, tx pgx.Tx, settings map[string]string) error {
+	for _, name := range sessionSettings {
+		value, ok := settings[name]
+		if !ok {
+			continue
+		}
+
+		var sql string
+		switch {
+		case name == "role" && value == "":
+			sql = `set local role none`
+		case name == "role":
+			sql = `set local role ` + value
+		case value == "":
+			sql = fmt.Sprintf(`set local %s to default`, name)
+		default:
+			sql = fmt.Sprintf(`set local %s to %s`, name, value)
+		}
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+var directiveRe = regexp.MustCompile(`^--\s*migrate:(\w+)\s+(.+?)\s*$`)
+
+// directives returns session settings overridden by the leading comments of a
+// migration's first statement, e.g.
+//
+//	-- migrate:role app_owner
+//	-- migrate:lock_timeout 5s
+func directives(sql string) map[string]string {
+	var settings map[string]string
+	for _, line := range strings.Split(sql, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		match := directiveRe.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		name, value := match[1], match[2]
+
+		switch name {
+		case "role":
+			value = pgx.Identifier{strings.Trim(value, `"`)}.Sanitize()
+		case "search_path":
+			value = searchPath(strings.Split(value, ","))
+		case "lock_timeout", "statement_timeout", "idle_in_transaction_session_timeout":
+			value = literal(strings.Trim(value, `'`))
+		default:
+			// Other directives such as migrate:lint-ignore belong elsewhere.
+			continue
+		}
+		if settings == nil {
+			settings = map[string]string{}
+		}
+		settings[name] = value
+	}
+	return settings
+}
@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDirectives(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want map[string]string
+	}{
+		{"create table a (id int)", nil},
+		{"-- migrate:lint-ignore PG002\ncreate index on a (id)", nil},
+		{
+			"-- Backfill.\n-- migrate:role app_owner\n--migrate:lock_timeout 5s\n-- migrate:search_path app, public\ncreate table a (id int)",
+			map[string]string{"role": `"app_owner"`, "lock_timeout": `'5s'`, "search_path": `"app", "public"`},
+		},
+		{"create table a (id int);\n-- migrate:role app_owner", nil},
+		{"-- migrate:statement_timeout '0'\nselect 1", map[string]string{"statement_timeout": `'0'`}},
+	}
+
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, directives(tt.sql)); diff != "" {
+			t.Errorf("directives %q mismatch (-want +got):\n%s", tt.sql, diff)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shanna/migrate/driver"
+)
+
+// WatchdogAction is taken once a migration has been blocked for longer than
+// the watchdog threshold.
+type WatchdogAction int
+
+const (
+	// WatchdogLog only logs the blocking sessions.
+	WatchdogLog WatchdogAction = iota
+	// WatchdogCancel cancels the blocked migration statement, failing the run.
+	WatchdogCancel
+	// WatchdogCancelBlockers cancels the queries of the blocking sessions. A
+	// session idle in a transaction has no query to cancel and keeps its locks.
+	WatchdogCancelBlockers
+	// WatchdogTerminateBlockers terminates the blocking sessions.
+	WatchdogTerminateBlockers
+)
+
+func (a WatchdogAction) String() string {
+	switch a {
+	case WatchdogLog:
+		return "log"
+	case WatchdogCancel:
+		return "cancel"
+	case WatchdogCancelBlockers:
+		return "cancel-blockers"
+	case WatchdogTerminateBlockers:
+		return "terminate-blockers"
+	}
+	return fmt.Sprintf("watchdog(%d)", int(a))
+}
+
+// ParseWatchdogAction parses the String form of a WatchdogAction.
+func ParseWatchdogAction(s string) (WatchdogAction, error) {
+	for _, action := range []WatchdogAction{WatchdogLog, WatchdogCancel, WatchdogCancelBlockers, WatchdogTerminateBlockers} {
+		if action.String() == s {
+			return action, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown watchdog action %q", s)
+}
+
+// Blocker is a session holding a lock a migration is waiting on.
+type Blocker struct {
+	PID         int
+	Application string
+	State       string
+	Query       string
+	Duration    time.Duration // Since the blocker's transaction started.
+}
+
+// WithWatchdog checks every interval, on a second connection, whether the
+// running migration statement is waiting on locks held by other sessions and
+// logs each blocker's pid, query and duration. Seeing the queries of other
+// roles' sessions needs pg_read_all_stats. Also set with the x-watchdog DSN
+// parameter, e.g. x-watchdog=5s.
+func WithWatchdog(interval time.Duration) driver.Option {
+	return option(func(o *options) {
+		o.watchdog = interval
+	})
+}
+
+// WithWatchdogAction takes action once a statement has been blocked for after.
+// Signalling other roles' sessions needs pg_signal_backend. Also set with the
+// x-watchdog-action and x-watchdog-after DSN parameters, e.g.
+// x-watchdog-action=cancel&x-watchdog-after=1m.
+func WithWatchdogAction(action WatchdogAction, after time.Duration) driver.Option {
+	return option(func(o *options) {
+		o.watchdogAction = action
+		o.watchdogAfter = after
+	})
+}
+
+// watch the running migration for lock contention until the returned stop
+// function is called. The second connection is only opened on the first
+// check so quick migrations never pay for it.
+func (p *Postgres) watch(ctx context.Context, name string) (stop func()) {
+	if p.watchdog <= 0 {
+		return func() {}
+	}
+
+	// Ask the server since behind a pooler such as pgbouncer the pid reported
+	// at startup isn't the server connection running the migration.
+	var pid int
+	if err := p.tx.QueryRow(ctx, `select pg_backend_pid()`).Scan(&pid); err != nil {
+		p.logger.Error(fmt.Sprintf("migrate watchdog %s", name), "driver", "postgres", "error", err)
+		return func() {}
+	}
+	config := p.tx.Conn().Config()
+	config.OnNotice = nil
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		var conn *pgx.Conn
+		defer func() {
+			if conn != nil {
+				conn.Close(context.Background())
+			}
+		}()
+
+		ticker := time.NewTicker(p.watchdog)
+		defer ticker.Stop()
+
+		cancelled := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if conn == nil {
+				var err error
+				if conn, err = pgx.ConnectConfig(ctx, config); err != nil {
+					if ctx.Err() == nil {
+						p.logger.Error(fmt.Sprintf("migrate watchdog %s", name), "driver", "postgres", "error", err)
+					}
+					return
+				}
+			}
+
+			waited, blockers, err := p.blockers(ctx, conn, pid)
+			if err != nil {
+				if ctx.Err() == nil {
+					p.logger.Error(fmt.Sprintf("migrate watchdog %s", name), "driver", "postgres", "error", err)
+				}
+				return
+			}
+
+			for _, blocker := range blockers {
+				p.warn(fmt.Sprintf("migrate blocked %s", name), "driver", "postgres", "waiting", waited,
+					"blocker_pid", blocker.PID, "blocker_application", blocker.Application, "blocker_state", blocker.State,
+					"blocker_query", blocker.Query, "blocker_duration", blocker.Duration)
+			}
+
+			if len(blockers) == 0 || p.watchdogAction == WatchdogLog || waited < p.watchdogAfter || cancelled {
+				continue
+			}
+			if err := p.act(ctx, conn, name, pid, blockers); err != nil {
+				p.logger.Error(fmt.Sprintf("migrate watchdog %s", name), "driver", "postgres", "error", err, "action", p.watchdogAction.String())
+			}
+			// The migration fails once cancelled so there is no more to do.
+			cancelled = p.watchdogAction == WatchdogCancel
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// blockers returns how long the migration's statement has been running and
+// the sessions blocking it, if any.
+func (p *Postgres) blockers(ctx context.Context, conn *pgx.Conn, pid int) (time.Duration, []Blocker, error) {
+	rows, err := conn.Query(ctx, `
+select
+  b.pid,
+  coalesce(b.application_name, ''),
+  coalesce(b.state, ''),
+  coalesce(b.query, ''),
+  (extract(epoch from now() - coalesce(b.xact_start, b.query_start, now())) * 1000)::bigint,
+  (extract(epoch from now() - m.query_start) * 1000)::bigint
+from pg_stat_activity m
+cross join lateral unnest(pg_blocking_pids(m.pid)) as blocking(pid)
+join pg_stat_activity b on b.pid = blocking.pid
+where m.pid = $1
+order by b.pid;
+`, pid)
+	if err != nil {
+		return 0, nil, fmt.Errorf("watchdog blockers: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		waited   time.Duration
+		blockers []Blocker
+	)
+	for rows.Next() {
+		var (
+			blocker            Blocker
+			duration, waitedMs int64
+		)
+		if err := rows.Scan(&blocker.PID, &blocker.Application, &blocker.State, &blocker.Query, &duration, &waitedMs); err != nil {
+			return 0, nil, fmt.Errorf("watchdog blockers: %w", err)
+		}
+		blocker.Duration = time.Duration(duration) * time.Millisecond
+		waited = time.Duration(waitedMs) * time.Millisecond
+		blockers = append(blockers, blocker)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("watchdog blockers: %w", err)
+	}
+	return waited, blockers, nil
+}
+
+// act on a migration blocked beyond the threshold.
+func (p *Postgres) act(ctx context.Context, conn *pgx.Conn, name string, pid int, blockers []Blocker) error {
+	signal := "pg_cancel_backend"
+	if p.watchdogAction == WatchdogTerminateBlockers {
+		signal = "pg_terminate_backend"
+	}
+
+	targets := []int{pid}
+	if p.watchdogAction != WatchdogCancel {
+		targets = targets[:0]
+		for _, blocker := range blockers {
+			targets = append(targets, blocker.PID)
+		}
+	}
+
+	for _, target := range targets {
+		p.warn(fmt.Sprintf("migrate watchdog %s", name), "driver", "postgres", "action", p.watchdogAction.String(), "pid", target)
+		if _, err := conn.Exec(ctx, fmt.Sprintf(`select %s($1)`, signal), target); err != nil {
+			return fmt.Errorf("%s %d: %w", signal, target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package sqlite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shanna/migrate/driver"
+)
+
+// attachment is a database file attached under a schema name.
+type attachment struct {
+	name string
+	path string
+}
+
+// dsnOptions strips migrate's own x- parameters from dsn, returning them as
+// options.
+func dsnOptions(dsn string) (string, []driver.Option, error) {
+	dsn, values, err := driver.SplitDSNParams(dsn, "x-attach", "x-pragma", "x-lock-wait", "x-user-version", "x-application-id", "x-backup", "x-backup-keep", "x-rebuild")
+	if err != nil || len(values) == 0 {
+		return dsn, nil, err
+	}
+	if err := driver.DSNParamNeeds(values, "x-backup-keep", "x-backup"); err != nil {
+		return "", nil, err
+	}
+
+	var opts []driver.Option
+	for _, value := range values["x-attach"] {
+		name, path, ok := strings.Cut(value, "=")
+		if !ok || name == "" || path == "" {
+			return "", nil, fmt.Errorf("parse dsn: x-attach %q is not name=path", value)
+		}
+		opts = append(opts, WithAttach(name, path))
+	}
+	for _, raw := range values["x-pragma"] {
+		name, value, ok := strings.Cut(raw, "=")
+		if !ok || name == "" || value == "" {
+			return "", nil, fmt.Errorf("parse dsn: x-pragma %q is not name=value", raw)
+		}
+		opts = append(opts, WithPragma(name, value))
+	}
+	if values.Has("x-lock-wait") {
+		wait, err := time.ParseDuration(values.Get("x-lock-wait"))
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: x-lock-wait: %w", err)
+		}
+		opts = append(opts, WithLockWait(wait))
+	}
+
+	if values.Has("x-rebuild") {
+		enabled, err := strconv.ParseBool(values.Get("x-rebuild"))
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: x-rebuild: %w", err)
+		}
+		if enabled {
+			opts = append(opts, WithRebuild())
+		}
+	}
+
+	if values.Has("x-user-version") {
+		enabled, err := strconv.ParseBool(values.Get("x-user-version"))
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: x-user-version: %w", err)
+		}
+		if enabled {
+			opts = append(opts, WithUserVersion())
+		}
+	}
+	if values.Has("x-application-id") {
+		id, err := strconv.ParseInt(values.Get("x-application-id"), 0, 32)
+		if err != nil {
+			return "", nil, fmt.Errorf("parse dsn: x-application-id: %w", err)
+		}
+		opts = append(opts, WithApplicationID(int32(id)))
+	}
+
+	if values.Has("x-backup") {
+		keep := 0
+		if values.Has("x-backup-keep") {
+			var err error
+			if keep, err = strconv.Atoi(values.Get("x-backup-keep")); err != nil {
+				return "", nil, fmt.Errorf("parse dsn: x-backup-keep: %w", err)
+			}
+		}
+		opts = append(opts, WithBackup(values.Get("x-backup"), keep))
+	}
+	return dsn, opts, nil
+}
+
+// quote an identifier.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// databases returns the schema names of the main, temp and attached databases.
+func (s *Sqlite) databases() (map[string]bool, error) {
+	rows, err := s.db.Query(`select name from pragma_database_list`)
+	if err != nil {
+		return nil, fmt.Errorf("database list: %w", err)
+	}
+	defer rows.Close()
+
+	databases := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("database list: %w", err)
+		}
+		databases[name] = true
+	}
+	return databases, rows.Err()
+}
+
+// attach databases not already attached. SQLite refuses to ATTACH inside a
+// transaction so this runs before Begin starts one.
+func (s *Sqlite) attach() error {
+	databases, err := s.databases()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range s.attachments {
+		if databases[a.name] {
+			continue
+		}
+		if _, err := s.db.Exec(`attach database ? as `+quote(a.name), a.path); err != nil {
+			return fmt.Errorf("attach %s: %w", a.name, err)
+		}
+		databases[a.name] = true
+	}
+
+	// A schema naming an attached database holds the history table for real.
+	// main and temp are always listed but are never attached, so the history
+	// stays in main_schema_migrations rather than moving or being thrown away.
+	s.schemaAttached = databases[s.schema] && s.schema != "main" && s.schema != "temp"
+	return nil
+}
@@ -0,0 +1,101 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shanna/migrate/driver"
+)
+
+// WithBackup snapshots the main database file into dir with VACUUM INTO
+// before the first migration a run applies, keeping the keep most recent
+// snapshots or all when keep is zero. Also set with the x-backup=dir and
+// x-backup-keep DSN parameters. Use Restore, or migrate restore, to put the
+// latest snapshot back.
+func WithBackup(dir string, keep int) driver.Option {
+	return option(func(o *options) {
+		o.backups = driver.Backups{Dir: dir, Keep: keep}
+	})
+}
+
+// path of the main database file, empty for an in-memory database.
+func (s *Sqlite) path() (string, error) {
+	var path string
+	if err := s.db.QueryRow(`select file from pragma_database_list where name = 'main'`).Scan(&path); err != nil {
+		return "", fmt.Errorf("database path: %w", err)
+	}
+	return path, nil
+}
+
+// backup snapshots the main database once a run, before its first migration
+// is applied. VACUUM INTO can't run inside the run's transaction so a second
+// connection reads the last committed state while the run holds the write
+// lock, which keeps other migrators from changing it in between.
+func (s *Sqlite) backup() error {
+	if s.backups.Dir == "" || s.backedUp {
+		return nil
+	}
+
+	path, err := s.path()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New("backup: in-memory databases have no file to back up")
+	}
+
+	if err := os.MkdirAll(s.backups.Dir, 0755); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	defer db.Close()
+
+	snapshot := s.backups.Path(path, time.Now())
+	if _, err := db.Exec(`vacuum main into ?`, snapshot); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	s.backedUp = true
+	s.logger.Info("migrate backup", "driver", "sqlite", "path", snapshot)
+	return s.backups.Prune(path)
+}
+
+// Restore replaces the database file with its latest snapshot. The database
+// is closed first so only a driver created with New can restore; nothing else
+// may have the file open.
+func (s *Sqlite) Restore() (string, error) {
+	defer s.close()
+
+	if s.backups.Dir == "" {
+		return "", errors.New("restore: no backup directory configured")
+	}
+	if !s.owned {
+		return "", errors.New("restore: the database belongs to the caller")
+	}
+
+	path, err := s.path()
+	if err != nil {
+		return "", err
+	}
+	latest, err := s.backups.Latest(path)
+	if err != nil {
+		return "", err
+	}
+
+	s.close()
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("restore: %w", err)
+		}
+	}
+	if err := driver.CopyFile(path, latest); err != nil {
+		return "", fmt.Errorf("restore: %w", err)
+	}
+	s.logger.Info("migrate restore", "driver", "sqlite", "path", latest)
+	return latest, nil
+}
@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/shanna/migrate/driver"
+	msqlite "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// lockPoll is how often Begin retries a busy database while waiting.
+const lockPoll = 100 * time.Millisecond
+
+// ErrLocked is returned by Begin when another connection holds the database
+// lock for longer than the lock wait.
+var ErrLocked = driver.ErrLocked
+
+// conn adapts a connection pinned from a pool to queryer so Begin, Migrate
+// and Commit always share one connection and so one transaction.
+type conn struct {
+	*sql.Conn
+}
+
+func (c conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.QueryRowContext(context.Background(), query, args...)
+}
+
+// pin a single connection from db.
+func pin(db *sql.DB) (*sql.Conn, error) {
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	return c, nil
+}
+
+var pragmaNameRe = regexp.MustCompile(`^\w+$`)
+var pragmaValueRe = regexp.MustCompile(`^[\w.+-]+$`)
+
+// pragmas applies the configured pragmas to the pinned connection.
+func (s *Sqlite) pragmas() error {
+	for _, p := range s.pragmaList {
+		if !pragmaNameRe.MatchString(p.name) || !pragmaValueRe.MatchString(p.value) {
+			return fmt.Errorf("pragma %s = %s: invalid name or value", p.name, p.value)
+		}
+		if _, err := s.db.Exec(fmt.Sprintf(`pragma %s = %s`, p.name, p.value)); err != nil {
+			return fmt.Errorf("pragma %s: %w", p.name, err)
+		}
+	}
+	return nil
+}
+
+// begin starts the transaction, retrying while another connection holds the
+// database lock for up to the lock wait.
+func (s *Sqlite) begin() error {
+	deadline := time.Now().Add(s.lockWait)
+	for {
+		_, err := s.db.Exec(s.beginSQL())
+		if err == nil || !isBusy(err) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: database locked by another connection after waiting %s", ErrLocked, s.lockWait)
+		}
+		s.logger.Debug("migrate waiting for lock", "driver", "sqlite")
+		time.Sleep(lockPoll)
+	}
+}
+
+// isBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED.
+func isBusy(err error) bool {
+	var sqliteErr *msqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
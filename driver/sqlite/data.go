@@ -0,0 +1,174 @@
+package sqlite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/shanna/migrate/driver"
+)
+
+// maxVariables bound to a single insert, SQLite's historic default limit.
+const maxVariables = 999
+
+// Load a data file into an existing table with batched inserts. CSV columns
+// come from the header row and empty fields load as NULL. NDJSON columns come
+// from each object's keys with nested values stored as JSON text.
+func (s *Sqlite) Load(name string, data io.Reader, load driver.Data) error {
+	stream := driver.NewDataStream(data)
+	return s.apply(name, stream.Checksum, func() error {
+		b := &batch{db: s.db, table: load.Table}
+
+		var err error
+		switch load.Format {
+		case driver.CSV:
+			err = loadCSV(b, stream)
+		case driver.NDJSON:
+			err = loadNDJSON(b, stream)
+		default:
+			return fmt.Errorf("load %s: %s: %w", name, load.Format, driver.ErrUnsupported)
+		}
+		if err == nil {
+			err = b.flush()
+		}
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "sqlite", "error", err, "table", load.Table, "format", load.Format.String())
+		}
+		return err
+	})
+}
+
+func loadCSV(b *batch, r io.Reader) error {
+	reader := csv.NewReader(r)
+	columns, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("csv header: %w", err)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("csv: %w", err)
+		}
+
+		row := make([]any, len(record))
+		for i, field := range record {
+			if field != "" {
+				row[i] = field
+			}
+		}
+		if err := b.add(columns, row); err != nil {
+			return err
+		}
+	}
+}
+
+func loadNDJSON(b *batch, r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(text) == 0 {
+			return nil
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("ndjson line %d: %w", line, err)
+		}
+		if len(bytes.TrimSpace(text)) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.UseNumber()
+		var object map[string]any
+		if err := decoder.Decode(&object); err != nil {
+			return fmt.Errorf("ndjson line %d: %w", line, err)
+		}
+		if decoder.More() {
+			return fmt.Errorf("ndjson line %d: more than one object", line)
+		}
+		if len(object) == 0 {
+			return fmt.Errorf("ndjson line %d: no columns", line)
+		}
+
+		columns := make([]string, 0, len(object))
+		for column := range object {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		row := make([]any, len(columns))
+		for i, column := range columns {
+			value, err := jsonValue(object[column])
+			if err != nil {
+				return fmt.Errorf("ndjson line %d: %w", line, err)
+			}
+			row[i] = value
+		}
+		if err := b.add(columns, row); err != nil {
+			return err
+		}
+	}
+}
+
+// jsonValue converts a decoded JSON value to one SQLite can bind.
+func jsonValue(value any) (any, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case map[string]any, []any:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+	return value, nil
+}
+
+// batch collects rows with the same columns into multi-row inserts.
+type batch struct {
+	db      queryer
+	table   string
+	columns []string
+	values  []any
+}
+
+func (b *batch) add(columns []string, row []any) error {
+	if !slices.Equal(columns, b.columns) || len(b.values)+len(row) > maxVariables {
+		if err := b.flush(); err != nil {
+			return err
+		}
+		b.columns = columns
+	}
+	b.values = append(b.values, row...)
+	return nil
+}
+
+func (b *batch) flush() error {
+	if len(b.values) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(b.columns))
+	for i, column := range b.columns {
+		quoted[i] = quote(column)
+	}
+	row := "(" + strings.Repeat("?, ", len(b.columns)-1) + "?)"
+	rows := len(b.values) / len(b.columns)
+	query := fmt.Sprintf(`insert into %s (%s) values %s`, b.table, strings.Join(quoted, ", "), strings.Repeat(row+", ", rows-1)+row)
+
+	if _, err := b.db.Exec(query, b.values...); err != nil {
+		return fmt.Errorf("insert %s: %w", b.table, err)
+	}
+	b.values = b.values[:0]
+	return nil
+}
@@ -0,0 +1,97 @@
+package sqlite
+
+import (
+	"time"
+
+	"github.com/shanna/migrate/driver"
+)
+
+// DefaultLockWait is how long Begin waits for another connection to release
+// the database.
+const DefaultLockWait = 30 * time.Second
+
+// options specific to the SQLite driver.
+type options struct {
+	attach   []attachment
+	pragmas  []pragma
+	lockWait time.Duration
+	rebuild  bool
+
+	userVersion   bool
+	applicationID int32
+	backups       driver.Backups
+}
+
+// pragma is applied to the connection before a run.
+type pragma struct {
+	name  string
+	value string
+}
+
+type optionsKey struct{}
+
+// option returns a driver.Option updating the SQLite specific options.
+func option(f func(*options)) driver.Option {
+	return func(c *driver.Config) {
+		o, _ := c.Value(optionsKey{}).(*options)
+		if o == nil {
+			o = &options{lockWait: DefaultLockWait}
+			c.SetValue(optionsKey{}, o)
+		}
+		f(o)
+	}
+}
+
+// configOptions returns the SQLite specific options set on config.
+func configOptions(config *driver.Config) options {
+	if o, ok := config.Value(optionsKey{}).(*options); ok {
+		return *o
+	}
+	return options{lockWait: DefaultLockWait}
+}
+
+// WithAttach attaches the database file at path as schema name before
+// migrating, so migrations can use name.table. Also set with one or more
+// x-attach=name=path DSN parameters.
+//
+// Naming an attached database with WithSchema keeps the history table inside
+// it as name.table rather than the schema_table prefix used otherwise.
+//
+// SQLite can't attach inside a transaction, so with NewFromTx attach before
+// beginning it. Attachments belong to a single connection.
+func WithAttach(name, path string) driver.Option {
+	return option(func(o *options) {
+		o.attach = append(o.attach, attachment{name: name, path: path})
+	})
+}
+
+// WithPragma sets a pragma such as journal_mode, foreign_keys or busy_timeout
+// on the driver's connection before migrating. Also set with one or more
+// x-pragma=name=value DSN parameters. Pragmas can't be applied to a caller's
+// transaction.
+func WithPragma(name, value string) driver.Option {
+	return option(func(o *options) {
+		o.pragmas = append(o.pragmas, pragma{name: name, value: value})
+	})
+}
+
+// WithRebuild turns foreign key enforcement off for the run so tables can be
+// rebuilt with the -- migrate:rebuild directive, since dropping the old table
+// would otherwise fire its cascades and enforcement can't be changed inside a
+// transaction. Cascading actions don't fire in any migration of such a run and
+// Commit checks foreign keys instead. Also set with the x-rebuild DSN
+// parameter, e.g. x-rebuild=true.
+func WithRebuild() driver.Option {
+	return option(func(o *options) {
+		o.rebuild = true
+	})
+}
+
+// WithLockWait sets how long Begin waits for another connection to release
+// the database before failing with ErrLocked; zero fails straight away. Also
+// set with the x-lock-wait DSN parameter, e.g. x-lock-wait=1m.
+func WithLockWait(wait time.Duration) driver.Option {
+	return option(func(o *options) {
+		o.lockWait = wait
+	})
+}
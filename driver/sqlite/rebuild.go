@@ -0,0 +1,277 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/shanna/migrate/statement"
+)
+
+// rebuildRe matches the directive asking for the table in the following
+// CREATE TABLE to be rebuilt to the new definition.
+var rebuildRe = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*migrate:rebuild[ \t]*$`)
+
+const identifier = "(?:\"(?:[^\"]|\"\")+\"|`[^`]+`|\\[[^\\]]+\\]|\\w+)"
+
+var (
+	rebuildCreateRe = regexp.MustCompile(`(?is)^create\s+table\s+(?:if\s+not\s+exists\s+)?(` + identifier + `)(?:\s*\.\s*(` + identifier + `))?`)
+	objectNameRe    = regexp.MustCompile(`(?is)^create\s+(?:unique\s+)?(?:index|trigger)\s+(?:if\s+not\s+exists\s+)?`)
+)
+
+// isRebuild reports whether a statement's leading comments hold the rebuild
+// directive.
+func isRebuild(sql string) bool {
+	return rebuildRe.MatchString(sql[:statement.LeadingComments(sql, statement.SQLite)])
+}
+
+// rebuild changes a table to the definition in a CREATE TABLE statement,
+// following SQLite's procedure for schema changes ALTER TABLE can't make:
+// create the new table under a temporary name, copy the columns both share,
+// drop the old table, rename the new one into place, recreate the old indexes
+// and triggers, then check foreign keys.
+//
+// Dropping a table with foreign keys enforced would fire cascades, so with
+// WithRebuild Begin turns enforcement off for the run.
+func (s *Sqlite) rebuild(create string) error {
+	body := create[statement.LeadingComments(create, statement.SQLite):]
+	match := rebuildCreateRe.FindStringSubmatchIndex(body)
+	if match == nil {
+		return errors.New("rebuild: directive must be followed by a CREATE TABLE")
+	}
+
+	var foreignKeys bool
+	if err := s.db.QueryRow(`pragma foreign_keys`).Scan(&foreignKeys); err != nil {
+		return fmt.Errorf("rebuild: %w", err)
+	}
+	if foreignKeys {
+		return errors.New("rebuild: foreign keys are enforced so dropping the old table would fire cascades; turn them off for the run with WithRebuild or x-rebuild=true, which can't be done inside a caller's transaction")
+	}
+
+	schema, table := "main", unquote(body[match[2]:match[3]])
+	if match[4] >= 0 {
+		schema, table = table, unquote(body[match[4]:match[5]])
+	}
+	prefix := quote(schema) + "."
+	tmp := table + "_migrate_rebuild"
+
+	objects, err := s.tableObjects(schema, table)
+	if err != nil {
+		return err
+	}
+	oldColumns, err := s.columns(schema, table)
+	if err != nil {
+		return err
+	}
+	if len(oldColumns) == 0 {
+		return fmt.Errorf("rebuild: no such table %s.%s", schema, table)
+	}
+	old := map[string]bool{}
+	for _, column := range oldColumns {
+		old[column] = true
+	}
+	sequence, hasSequence := s.sequence(prefix, table)
+
+	if _, err := s.db.Exec(body[:match[0]] + "create table " + prefix + quote(tmp) + body[match[1]:]); err != nil {
+		return fmt.Errorf("rebuild create: %w", err)
+	}
+
+	columns, err := s.columns(schema, tmp)
+	if err != nil {
+		return err
+	}
+	var common []string
+	for _, column := range columns {
+		if old[column] {
+			common = append(common, quote(column))
+		}
+	}
+	if len(common) > 0 {
+		list := strings.Join(common, ", ")
+		copySQL := fmt.Sprintf(`insert into %s%s (%s) select %s from %s%s`, prefix, quote(tmp), list, list, prefix, quote(table))
+		if _, err := s.db.Exec(copySQL); err != nil {
+			return fmt.Errorf("rebuild copy: %w", err)
+		}
+	}
+
+	// Legacy renames leave views and triggers elsewhere that name the table
+	// alone instead of failing the rename over a table that is briefly gone.
+	var legacy bool
+	if err := s.db.QueryRow(`pragma legacy_alter_table`).Scan(&legacy); err != nil {
+		return fmt.Errorf("rebuild: %w", err)
+	}
+	steps := []string{
+		`pragma legacy_alter_table = on`,
+		fmt.Sprintf(`drop table %s%s`, prefix, quote(table)),
+		fmt.Sprintf(`alter table %s%s rename to %s`, prefix, quote(tmp), quote(table)),
+	}
+	if !legacy {
+		steps = append(steps, `pragma legacy_alter_table = off`)
+	}
+	for _, step := range steps {
+		if _, err := s.db.Exec(step); err != nil {
+			return fmt.Errorf("rebuild: %w", err)
+		}
+	}
+
+	for _, object := range objects {
+		if _, err := s.db.Exec(object.sql); err != nil {
+			return fmt.Errorf("rebuild recreate %s %s: %w", object.kind, object.name, err)
+		}
+	}
+
+	if hasSequence {
+		_, err := s.db.Exec(fmt.Sprintf(`update %ssqlite_sequence set seq = ? where name = ? and seq < ?`, prefix), sequence, table, sequence)
+		if err != nil {
+			return fmt.Errorf("rebuild sequence: %w", err)
+		}
+	}
+
+	return s.foreignKeyCheck(schema)
+}
+
+type schemaObject struct {
+	kind, name, sql string
+}
+
+// tableObjects returns the indexes and triggers on a table. Indexes SQLite
+// creates for constraints have no SQL and come back with the new definition.
+// Names are qualified with schema so they're recreated alongside the table.
+func (s *Sqlite) tableObjects(schema, table string) ([]schemaObject, error) {
+	prefix := quote(schema) + "."
+	rows, err := s.db.Query(fmt.Sprintf(`select type, name, sql from %ssqlite_master where tbl_name = ? and type in ('index', 'trigger') and sql is not null`, prefix), table)
+	if err != nil {
+		return nil, fmt.Errorf("rebuild schema: %w", err)
+	}
+	defer rows.Close()
+
+	var objects []schemaObject
+	for rows.Next() {
+		var object schemaObject
+		if err := rows.Scan(&object.kind, &object.name, &object.sql); err != nil {
+			return nil, fmt.Errorf("rebuild schema: %w", err)
+		}
+		if end := objectNameRe.FindStringIndex(object.sql); end != nil {
+			object.sql = object.sql[:end[1]] + prefix + object.sql[end[1]:]
+		}
+		objects = append(objects, object)
+	}
+	return objects, rows.Err()
+}
+
+// columns returns a table's column names in order.
+func (s *Sqlite) columns(schema, table string) ([]string, error) {
+	rows, err := s.db.Query(`select name from pragma_table_info(?, ?)`, table, schema)
+	if err != nil {
+		return nil, fmt.Errorf("rebuild columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("rebuild columns: %w", err)
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// sequence returns the AUTOINCREMENT sequence of a table if it has one.
+func (s *Sqlite) sequence(prefix, table string) (int64, bool) {
+	var seq int64
+	err := s.db.QueryRow(fmt.Sprintf(`select seq from %ssqlite_sequence where name = ?`, prefix), table).Scan(&seq)
+	return seq, err == nil
+}
+
+// foreignKeyCheck fails if any foreign key in schema is violated.
+func (s *Sqlite) foreignKeyCheck(schema string) error {
+	rows, err := s.db.Query(fmt.Sprintf(`pragma %s.foreign_key_check`, quote(schema)))
+	if err != nil {
+		return fmt.Errorf("foreign key check: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		violations    int
+		table, parent string
+		rowid         sql.NullInt64
+		fkid          int
+	)
+	for rows.Next() {
+		if violations == 0 {
+			if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+				return fmt.Errorf("foreign key check: %w", err)
+			}
+		}
+		violations++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("foreign key check: %w", err)
+	}
+	if violations > 0 {
+		return fmt.Errorf("foreign key check: %d rows violate foreign keys, first %s.%s rowid %d referencing %s", violations, schema, table, rowid.Int64, parent)
+	}
+	return nil
+}
+
+// unquote an identifier.
+func unquote(name string) string {
+	switch {
+	case strings.HasPrefix(name, `"`):
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	case strings.HasPrefix(name, "`"), strings.HasPrefix(name, "["):
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// disableForeignKeys turns off foreign key enforcement for a run with
+// WithRebuild, as the SQLite procedure for rebuilding tables requires, since
+// it can't be changed inside a transaction. Other runs and a joined
+// transaction are left alone so cascading actions still fire.
+func (s *Sqlite) disableForeignKeys() error {
+	if !s.rebuildTables || s.savepoint {
+		return nil
+	}
+	var enabled bool
+	if err := s.db.QueryRow(`pragma foreign_keys`).Scan(&enabled); err != nil {
+		return fmt.Errorf("foreign keys: %w", err)
+	}
+	if !enabled {
+		return nil
+	}
+	if _, err := s.db.Exec(`pragma foreign_keys = off`); err != nil {
+		return fmt.Errorf("foreign keys: %w", err)
+	}
+	s.foreignKeys = true
+	return nil
+}
+
+// restoreForeignKeys turns enforcement back on after the run.
+func (s *Sqlite) restoreForeignKeys() {
+	if !s.foreignKeys {
+		return
+	}
+	if _, err := s.db.Exec(`pragma foreign_keys = on`); err != nil {
+		s.logger.Error("migrate restore foreign keys", "driver", "sqlite", "error", err)
+	}
+	s.foreignKeys = false
+}
+
+// foreignKeyChecks checks foreign keys in every database.
+func (s *Sqlite) foreignKeyChecks() error {
+	databases, err := s.databases()
+	if err != nil {
+		return err
+	}
+	for database := range databases {
+		if err := s.foreignKeyCheck(database); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,15 +1,16 @@
-package postgres
+package sqlite
 
 import (
-	"crypto/sha512"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"time"
 
 	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
 	_ "modernc.org/sqlite"
 )
 
@@ -23,15 +24,105 @@ type migrate struct {
 	checksum  string
 }
 
+// queryer is satisfied by *sql.DB, *sql.Tx and conn.
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 type Sqlite struct {
-	db        *sql.DB
+	db        queryer
+	closers   []io.Closer // Closed in order by Commit or Rollback.
+	owned     bool        // The driver opened the database.
+	savepoint bool        // Join the caller's transaction with a savepoint.
 	logger    driver.Logger
 	schema    string
 	tableName string
 	inTx      bool
+
+	attachments    []attachment
+	schemaAttached bool // Schema names an attached database.
+	rebuildTables  bool // Turn enforcement off so tables can be rebuilt.
+	foreignKeys    bool // Enforcement was turned off for the run.
+	pragmaList     []pragma
+	lockWait       time.Duration
+
+	userVersion   bool  // Track migrations in PRAGMA user_version.
+	applicationID int32 // Expected PRAGMA application_id.
+	backups       driver.Backups
+	backedUp      bool             // Snapshot taken this run.
+	history       bool             // Record migrations in the history table.
+	version       int32            // Current user_version.
+	startVersion  int32            // user_version when the run began.
+	recorded      int32            // Highest version in the history table.
+	versions      map[int32]string // Versions seen this run.
 }
 
+// New opens dsn, which may carry a sqlite: scheme so the command can pick the
+// driver from it, e.g. sqlite:app.db or sqlite:///var/lib/app.db.
 func New(dsn string, opts ...driver.Option) (driver.Migrator, error) {
+	dsn = driver.TrimScheme(dsn, "sqlite")
+	dsn, dsnOpts, err := dsnOptions(dsn)
+	if err != nil {
+		return nil, err
+	}
+	config := newConfig(append(dsnOpts, opts...))
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sql open: %w", err)
+	}
+
+	c, err := pin(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := newSqlite(conn{c}, config)
+	s.closers = []io.Closer{c, db}
+	s.owned = true
+	return s, nil
+}
+
+// NewFromDB creates a migrator using an existing database handle. The caller
+// keeps ownership and Commit or Rollback never close it, though a single
+// connection is held from its pool until then.
+func NewFromDB(db *sql.DB, opts ...driver.Option) (driver.Migrator, error) {
+	if db == nil {
+		return nil, errors.New("sqlite: nil database")
+	}
+
+	c, err := pin(db)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSqlite(conn{c}, newConfig(opts))
+	s.closers = []io.Closer{c}
+	return s, nil
+}
+
+// NewFromTx creates a migrator that joins the caller's transaction. Migrations
+// run inside a savepoint that Commit releases and Rollback rolls back, leaving
+// the caller to commit or roll back the transaction itself.
+func NewFromTx(tx *sql.Tx, opts ...driver.Option) (driver.Migrator, error) {
+	if tx == nil {
+		return nil, errors.New("sqlite: nil transaction")
+	}
+	s := newSqlite(tx, newConfig(opts))
+	if len(s.pragmaList) > 0 {
+		return nil, errors.New("sqlite: pragmas can't be applied inside a transaction")
+	}
+	if s.backups.Dir != "" {
+		return nil, errors.New("sqlite: backups can't be taken inside a transaction")
+	}
+	s.savepoint = true
+	return s, nil
+}
+
+func newConfig(opts []driver.Option) *driver.Config {
 	config := &driver.Config{
 		Schema:    driver.DefaultSchema,
 		TableName: driver.DefaultTableName,
@@ -40,24 +131,42 @@ func New(dsn string, opts ...driver.Option) (driver.Migrator, error) {
 	for _, opt := range opts {
 		opt(config)
 	}
+	return config
+}
 
-	conn, err := sql.Open("sqlite", dsn)
-	if err != nil {
-		return nil, fmt.Errorf("sql open: %w", err)
-	}
+func newSqlite(db queryer, config *driver.Config) *Sqlite {
+	options := configOptions(config)
 
-	s := &Sqlite{
-		db:        conn,
-		logger:    config.Logger,
-		schema:    config.Schema,
-		tableName: config.TableName,
+	return &Sqlite{
+		db:            db,
+		logger:        config.Logger,
+		schema:        config.Schema,
+		tableName:     config.TableName,
+		attachments:   options.attach,
+		pragmaList:    options.pragmas,
+		lockWait:      options.lockWait,
+		rebuildTables: options.rebuild,
+
+		userVersion:   options.userVersion,
+		applicationID: options.applicationID,
+		backups:       options.backups,
 	}
+}
 
-	return s, nil
+// close the pinned connection and an owned database.
+func (s *Sqlite) close() {
+	for _, closer := range s.closers {
+		closer.Close()
+	}
+	s.closers = nil
 }
 
-// qualifiedTableName returns schema_tableName since SQLite doesn't support schemas.
+// qualifiedTableName returns schema.tableName when the schema names an
+// attached database, otherwise schema_tableName in the main database.
 func (s *Sqlite) qualifiedTableName() string {
+	if s.schemaAttached {
+		return quote(s.schema) + "." + quote(s.tableName)
+	}
 	return s.schema + "_" + s.tableName
 }
 
@@ -84,82 +193,231 @@ func (s *Sqlite) insertMigrationSQL() string {
 	return fmt.Sprintf(`insert into %s (name, checksum) values (?, ?)`, s.qualifiedTableName())
 }
 
-func (s *Sqlite) Begin() error {
+// Transaction control statements. A joined transaction uses a savepoint.
+func (s *Sqlite) beginSQL() string {
+	if s.savepoint {
+		return "SAVEPOINT migrate"
+	}
+	// IMMEDIATE still prevents concurrent migrations but lets the backup's
+	// connection read the database before the first migration.
+	if s.backups.Dir != "" {
+		return "BEGIN IMMEDIATE"
+	}
 	// Use EXCLUSIVE transaction to prevent concurrent migrations.
-	if _, err := s.db.Exec("BEGIN EXCLUSIVE"); err != nil {
-		return fmt.Errorf("begin exclusive: %w", err)
+	return "BEGIN EXCLUSIVE"
+}
+
+func (s *Sqlite) rollbackSQL() string {
+	if s.savepoint {
+		return "ROLLBACK TO migrate; RELEASE migrate"
+	}
+	return "ROLLBACK"
+}
+
+func (s *Sqlite) commitSQL() string {
+	if s.savepoint {
+		return "RELEASE migrate"
+	}
+	return "COMMIT"
+}
+
+func (s *Sqlite) Begin() error {
+	if err := s.pragmas(); err != nil {
+		return err
+	}
+
+	if err := s.attach(); err != nil {
+		return err
+	}
+
+	if err := s.disableForeignKeys(); err != nil {
+		return err
+	}
+
+	if err := s.begin(); err != nil {
+		s.restoreForeignKeys()
+		return fmt.Errorf("begin: %w", err)
 	}
 	s.inTx = true
 
-	// Ensure migration table exists (idempotent).
-	if _, err := s.db.Exec(s.setupSQL()); err != nil {
-		s.db.Exec("ROLLBACK")
+	if err := s.setup(); err != nil {
+		s.db.Exec(s.rollbackSQL())
 		s.inTx = false
-		return fmt.Errorf("setup: %w", err)
+		s.restoreForeignKeys()
+		return err
 	}
 
 	return nil
 }
 
+// setup the history table, or read user_version, inside the transaction.
+func (s *Sqlite) setup() error {
+	if err := s.checkApplicationID(); err != nil {
+		return err
+	}
+
+	if !s.userVersion {
+		// Ensure migration table exists (idempotent).
+		if _, err := s.db.Exec(s.setupSQL()); err != nil {
+			return fmt.Errorf("setup: %w", err)
+		}
+		s.history = true
+		return nil
+	}
+
+	history, err := s.historyExists()
+	if err != nil {
+		return err
+	}
+	s.history = history
+	return s.setupUserVersion()
+}
+
 func (s *Sqlite) Rollback() error {
-	defer s.db.Close()
+	defer s.close()
 	if s.inTx {
-		s.db.Exec("ROLLBACK")
+		s.db.Exec(s.rollbackSQL())
 		s.inTx = false
+		s.restoreForeignKeys()
 	}
 	return nil
 }
 
 func (s *Sqlite) Commit() error {
-	defer s.db.Close()
+	defer s.close()
 	if s.inTx {
-		if _, err := s.db.Exec("COMMIT"); err != nil {
+		// Nothing was enforced during the run so check before committing.
+		if s.foreignKeys {
+			if err := s.foreignKeyChecks(); err != nil {
+				return err
+			}
+		}
+		if _, err := s.db.Exec(s.commitSQL()); err != nil {
 			return err
 		}
 		s.inTx = false
+		s.restoreForeignKeys()
 	}
 	return nil
 }
 
 func (s *Sqlite) Migrate(name string, data io.Reader) error {
-	// Shame you can't stream statements to the driver as well.
-	checksum := sha512.New()
-	reader := io.TeeReader(data, checksum)
-	statements, err := io.ReadAll(reader)
-	if err != nil {
-		return fmt.Errorf("read: %w", err)
-	}
+	stream := driver.NewStream(data, statement.SQLite)
+	return s.apply(name, stream.Checksum, func() error {
+		return s.statements(name, stream)
+	})
+}
 
-	rows, err := s.db.Query(s.selectMigrationSQL(), name)
-	if err != nil {
-		return fmt.Errorf("schema_migrations select previous %s", err)
+// apply runs a migration with run unless it has already run, then records it
+// in the history table and user_version as configured.
+func (s *Sqlite) apply(name string, checksum func() ([]byte, error), run func() error) error {
+	var version int32
+	if s.userVersion {
+		var err error
+		if version, err = migrationVersion(name); err != nil {
+			return err
+		}
+		if previous, ok := s.versions[version]; ok {
+			return fmt.Errorf("%q has the same version as %q", name, previous)
+		}
+		s.versions[version] = name
+
+		if !s.history {
+			if version <= s.startVersion {
+				s.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "sqlite", "user_version", s.version)
+				return nil
+			}
+			if version <= s.version {
+				return outOfOrder(name, version, s.version)
+			}
+		}
 	}
-	defer rows.Close()
 
-	if rows.Next() {
-		previous := migrate{}
-		err = rows.Scan(&previous.name, &previous.completed, &previous.checksum)
+	if s.history {
+		rows, err := s.db.Query(s.selectMigrationSQL(), name)
 		if err != nil {
-			return fmt.Errorf("schema_migrations scan previous %s", err)
+			return fmt.Errorf("schema_migrations select previous %s", err)
 		}
-		if base64.StdEncoding.EncodeToString(checksum.Sum(nil)) != previous.checksum {
-			return fmt.Errorf("%q has been altered since it was run on %s", previous.name, previous.completed)
+		defer rows.Close()
+
+		if rows.Next() {
+			previous := migrate{}
+			err = rows.Scan(&previous.name, &previous.completed, &previous.checksum)
+			if err != nil {
+				return fmt.Errorf("schema_migrations scan previous %s", err)
+			}
+			rows.Close()
+
+			sum, err := checksum()
+			if err != nil {
+				return fmt.Errorf("read: %w", err)
+			}
+			if base64.StdEncoding.EncodeToString(sum) != previous.checksum {
+				return fmt.Errorf("%q has been altered since it was run on %s", previous.name, previous.completed)
+			}
+
+			s.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "sqlite", "completed", previous.completed)
+			return nil
 		}
+		rows.Close()
 
-		s.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "sqlite", "completed", previous.completed)
-		return nil
+		if s.userVersion && version <= s.recorded {
+			return outOfOrder(name, version, s.recorded)
+		}
+		// Ran before the history table was kept alongside user_version.
+		if s.userVersion && version <= s.version {
+			s.logger.Debug(fmt.Sprintf("migrate skip %s", name), "driver", "sqlite", "user_version", s.version)
+			return nil
+		}
 	}
-	rows.Close()
 
-	if _, err := s.db.Exec(string(statements)); err != nil {
-		s.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "sqlite", "error", err, "sql", string(statements))
+	if err := s.backup(); err != nil {
 		return err
 	}
 
-	if _, err = s.db.Exec(s.insertMigrationSQL(), name, base64.StdEncoding.EncodeToString(checksum.Sum(nil))); err != nil {
-		return fmt.Errorf("schema_migrations insert %s", err)
+	if err := run(); err != nil {
+		return err
+	}
+
+	sum, err := checksum()
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	if s.history {
+		if _, err = s.db.Exec(s.insertMigrationSQL(), name, base64.StdEncoding.EncodeToString(sum)); err != nil {
+			return fmt.Errorf("schema_migrations insert %s", err)
+		}
+	}
+
+	if s.userVersion {
+		if err := s.setUserVersion(version); err != nil {
+			return err
+		}
+		if s.history && version > s.recorded {
+			s.recorded = version
+		}
 	}
 
 	s.logger.Debug(fmt.Sprintf("migrate %s", name), "driver", "sqlite")
 	return nil
 }
+
+// statements executes each statement of a migration.
+func (s *Sqlite) statements(name string, stream *driver.Stream) error {
+	for stream.Scan() {
+		stmt := stream.Statement()
+		if isRebuild(stmt.SQL) {
+			if err := s.rebuild(stmt.SQL); err != nil {
+				s.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "sqlite", "error", err, "statement", stmt.Index+1, "sql", stmt.SQL)
+				return err
+			}
+			continue
+		}
+		if _, err := s.db.Exec(stmt.SQL); err != nil {
+			s.logger.Error(fmt.Sprintf("migrate error %s", name), "driver", "sqlite", "error", err, "statement", stmt.Index+1, "sql", stmt.SQL)
+			return err
+		}
+	}
+	return nil
+}
@@ -1,11 +1,15 @@
-package postgres_test
+package sqlite_test
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	mdriver "github.com/shanna/migrate/driver"
 	driver "github.com/shanna/migrate/driver/sqlite"
 	_ "modernc.org/sqlite"
 )
@@ -55,6 +59,53 @@ func TestSqliteMigrateCommit(t *testing.T) {
 	rows.Close()
 }
 
+func TestSqliteMigrateTrigger(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	migrator, err := driver.New("file:" + dir + "/migrate.db")
+	if err != nil {
+		t.Skipf("connect %s", err)
+	}
+
+	migration := `
+create table trigger_test (id integer primary key, name text);
+create table trigger_log (name text);
+create trigger trigger_test_insert after insert on trigger_test begin
+  insert into trigger_log (name) values (new.name);
+  insert into trigger_log (name) values ('; not a terminator');
+end;
+insert into trigger_test (name) values ('woot');
+`
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate(`migrate trigger`, strings.NewReader(migration)); err != nil {
+		t.Fatalf("migrate trigger %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+dir+"/migrate.db")
+	if err != nil {
+		t.Fatalf("post migrate connnect %s", err)
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow(`select count(*) from trigger_log`).Scan(&count); err != nil {
+		t.Fatalf("post migrate select %s", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 trigger_log rows, got %d", count)
+	}
+}
+
 func TestSqliteMigrateRollback(t *testing.T) {
 	dir, err := os.MkdirTemp("", "migrate-*")
 	if err != nil {
@@ -144,3 +195,622 @@ func TestSqliteMigrateChecksumRollback(t *testing.T) {
 		t.Fatalf("expected table to not exist after rollback")
 	}
 }
+
+func TestSqliteNewFromDB(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open("sqlite", "file:"+dir+"/migrate.db")
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	defer db.Close()
+
+	migrator, err := driver.NewFromDB(db)
+	if err != nil {
+		t.Fatalf("new from db %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate(`migrate from db`, strings.NewReader(`create table from_db_test (id text)`)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	// The handle belongs to the caller and must still be open.
+	rows, err := db.Query(`select id from from_db_test`)
+	if err != nil {
+		t.Fatalf("post migrate select %s", err)
+	}
+	rows.Close()
+}
+
+func TestSqliteNewFromTx(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open("sqlite", "file:"+dir+"/migrate.db")
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin caller transaction %s", err)
+	}
+
+	migrator, err := driver.NewFromTx(tx)
+	if err != nil {
+		t.Fatalf("new from tx %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate(`migrate from tx`, strings.NewReader(`create table from_tx_test (id text)`)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	// Visible inside the caller's transaction.
+	rows, err := tx.Query(`select id from from_tx_test`)
+	if err != nil {
+		t.Fatalf("select inside transaction %s", err)
+	}
+	rows.Close()
+
+	// Gone once the caller rolls back.
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("rollback caller transaction %s", err)
+	}
+	if _, err = db.Query(`select id from from_tx_test`); err == nil {
+		t.Fatalf("expected table to not exist after caller rollback")
+	}
+}
+
+func TestSqliteAttach(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	migrator, err := driver.New("file:"+dir+"/main.db?x-attach=aux="+dir+"/aux.db", mdriver.WithSchema("aux"))
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate(`migrate attach`, strings.NewReader(`create table aux.attach_test (id text); insert into aux.attach_test values ('woot')`)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	// The migration and its history both live in the attached file.
+	db, err := sql.Open("sqlite", "file:"+dir+"/aux.db")
+	if err != nil {
+		t.Fatalf("post migrate connnect %s", err)
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow(`select count(*) from attach_test`).Scan(&count); err != nil {
+		t.Fatalf("post migrate select %s", err)
+	}
+	if err := db.QueryRow(`select count(*) from schema_migrations where name = 'migrate attach'`).Scan(&count); err != nil {
+		t.Fatalf("post migrate select history %s", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 history row, got %d", count)
+	}
+}
+
+func TestSqliteSchemaMainTemp(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// main and temp are always in the database list but aren't attached, so
+	// the history table keeps the schema_tableName form in main.
+	for _, schema := range []string{"main", "temp"} {
+		for run := 0; run < 2; run++ {
+			migrator, err := driver.New("file:"+dir+"/app.db", mdriver.WithSchema(schema))
+			if err != nil {
+				t.Fatalf("connect %s", err)
+			}
+			if err = migrator.Begin(); err != nil {
+				t.Fatalf("begin %s", err)
+			}
+			if err = migrator.Migrate("migrate "+schema, strings.NewReader("create table "+schema+"_test (id text)")); err != nil {
+				t.Fatalf("migrate %s run %d: %s", schema, run, err)
+			}
+			if err = migrator.Commit(); err != nil {
+				t.Fatalf("commit %s", err)
+			}
+		}
+	}
+
+	db, err := sql.Open("sqlite", "file:"+dir+"/app.db")
+	if err != nil {
+		t.Fatalf("post migrate connnect %s", err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"main_schema_migrations", "temp_schema_migrations"} {
+		var count int
+		if err := db.QueryRow(`select count(*) from ` + table).Scan(&count); err != nil || count != 1 {
+			t.Errorf("expected 1 history row in %s, got %d %v", table, count, err)
+		}
+	}
+}
+
+func TestSqliteRebuild(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := "file:" + dir + "/migrate.db?_pragma=foreign_keys(1)"
+	migrator, err := driver.New(dsn + "&x-rebuild=true")
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+
+	var migrations = []struct{ name, sql string }{
+		{`migrate rebuild: create`, `
+create table users (id integer primary key autoincrement, email text, legacy text);
+create index users_email_idx on users (email);
+create table posts (id integer primary key, user_id integer not null references users (id) on delete cascade);
+insert into users (email) values ('a@example.com'), ('b@example.com');
+insert into posts (user_id) values (1);
+delete from users where id = 2;
+insert into users (email) values ('c@example.com');
+`},
+		{`migrate rebuild: rebuild`, `
+-- migrate:rebuild
+create table users (
+  id integer primary key autoincrement,
+  email text not null check (email like '%@%')
+);
+`},
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	for _, migration := range migrations {
+		if err = migrator.Migrate(migration.name, strings.NewReader(migration.sql)); err != nil {
+			t.Fatalf("%s %s", migration.name, err)
+		}
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("post migrate connnect %s", err)
+	}
+	defer db.Close()
+
+	var got struct {
+		users, posts, index, sequence int
+		legacy                        string
+	}
+	db.QueryRow(`select count(*) from users`).Scan(&got.users)
+	db.QueryRow(`select count(*) from posts`).Scan(&got.posts)
+	db.QueryRow(`select count(*) from sqlite_master where name = 'users_email_idx'`).Scan(&got.index)
+	db.QueryRow(`select seq from sqlite_sequence where name = 'users'`).Scan(&got.sequence)
+	if got.users != 2 || got.posts != 1 || got.index != 1 || got.sequence != 3 {
+		t.Fatalf("expected 2 users, 1 post, the index and sequence 3, got %+v", got)
+	}
+	if _, err := db.Exec(`insert into users (email) values ('not an email')`); err == nil {
+		t.Fatalf("expected new check constraint")
+	}
+
+	// Foreign keys aren't enforced during a rebuild run, so violations fail
+	// the commit.
+	migrator, err = driver.New(dsn, driver.WithRebuild())
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	if err = migrator.Migrate(`migrate rebuild: orphan`, strings.NewReader(`delete from users where id = 1`)); err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err == nil || !strings.Contains(err.Error(), "foreign key check") {
+		t.Fatalf("expected foreign key check error, got %v", err)
+	}
+	migrator.Rollback()
+}
+
+func TestSqliteForeignKeyCascade(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := "file:" + dir + "/migrate.db?_pragma=foreign_keys(1)"
+	migrator, err := driver.New(dsn)
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+	err = migrator.Migrate(`migrate cascade`, strings.NewReader(`
+create table users (id integer primary key);
+create table posts (id integer primary key, user_id integer not null references users (id) on delete cascade);
+insert into users (id) values (1), (2);
+insert into posts (user_id) values (1), (2);
+delete from users where id = 1;
+`))
+	if err != nil {
+		t.Fatalf("migrate %s", err)
+	}
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("post migrate connnect %s", err)
+	}
+	defer db.Close()
+
+	var posts int
+	if err := db.QueryRow(`select count(*) from posts`).Scan(&posts); err != nil || posts != 1 {
+		t.Errorf("expected the cascade to delete 1 post leaving 1, got %d %v", posts, err)
+	}
+}
+
+func TestSqliteLockWait(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := "file:" + dir + "/migrate.db"
+	holder, err := driver.New(dsn + "?x-pragma=journal_mode=wal")
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	if err = holder.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	waiter, err := driver.New(dsn+"?x-lock-wait=200ms", driver.WithPragma("busy_timeout", "0"))
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	start := time.Now()
+	err = waiter.Begin()
+	if !errors.Is(err, driver.ErrLocked) {
+		t.Fatalf("expected locked error, got %v", err)
+	}
+	if waited := time.Since(start); waited < 200*time.Millisecond || waited > 5*time.Second {
+		t.Fatalf("expected to wait about 200ms, waited %s", waited)
+	}
+	waiter.Rollback()
+
+	if err = holder.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("post migrate connnect %s", err)
+	}
+	defer db.Close()
+	var mode string
+	if err := db.QueryRow(`pragma journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("expected wal journal mode, got %q %v", mode, err)
+	}
+}
+
+func TestSqliteUserVersion(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := "file:" + dir + "/migrate.db"
+	run := func(t *testing.T, dsn string, migrations []struct{ name, sql string }, opts ...mdriver.Option) {
+		t.Helper()
+		migrator, err := driver.New(dsn, opts...)
+		if err != nil {
+			t.Fatalf("connect %s", err)
+		}
+		if err = migrator.Begin(); err != nil {
+			t.Fatalf("begin %s", err)
+		}
+		for _, migration := range migrations {
+			if err = migrator.Migrate(migration.name, strings.NewReader(migration.sql)); err != nil {
+				t.Fatalf("%s %s", migration.name, err)
+			}
+		}
+		if err = migrator.Commit(); err != nil {
+			t.Fatalf("commit %s", err)
+		}
+	}
+	pragma := func(t *testing.T, dsn, name string) (value int) {
+		t.Helper()
+		db, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			t.Fatalf("connect %s", err)
+		}
+		defer db.Close()
+		if err := db.QueryRow(`pragma ` + name).Scan(&value); err != nil {
+			t.Fatalf("pragma %s %s", name, err)
+		}
+		return value
+	}
+
+	run(t, dsn+"?x-user-version=true&x-application-id=0x4d494752", []struct{ name, sql string }{
+		{`001_create.sql`, `create table version_test (id integer)`},
+		{`002_insert.sql`, `insert into version_test values (1)`},
+	})
+	if got := pragma(t, dsn, "user_version"); got != 2 {
+		t.Fatalf("expected user_version 2, got %d", got)
+	}
+	if got := pragma(t, dsn, "application_id"); got != 0x4d494752 {
+		t.Fatalf("expected application_id set, got %x", got)
+	}
+
+	// Files at or below user_version are skipped without a history table.
+	run(t, dsn, []struct{ name, sql string }{
+		{`001_create.sql`, `this no longer runs`},
+		{`002_insert.sql`, `insert into version_test values (1)`},
+		{`003_insert.sql`, `insert into version_test values (3)`},
+	}, driver.WithUserVersion(), driver.WithApplicationID(0x4d494752))
+	if got := pragma(t, dsn, "user_version"); got != 3 {
+		t.Fatalf("expected user_version 3, got %d", got)
+	}
+
+	migrator, err := driver.New(dsn, driver.WithUserVersion(), driver.WithApplicationID(42))
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	if err = migrator.Begin(); err == nil || !strings.Contains(err.Error(), "another application") {
+		t.Fatalf("expected application_id error, got %v", err)
+	}
+	migrator.Rollback()
+
+	// A database tracked by the history table moves over to user_version.
+	history := "file:" + dir + "/history.db"
+	run(t, history, []struct{ name, sql string }{
+		{`001_create.sql`, `create table version_test (id integer)`},
+		{`002_insert.sql`, `insert into version_test values (1)`},
+	})
+	run(t, history, []struct{ name, sql string }{
+		{`001_create.sql`, `create table version_test (id integer)`},
+		{`002_insert.sql`, `insert into version_test values (1)`},
+		{`003_insert.sql`, `insert into version_test values (3)`},
+	}, driver.WithUserVersion())
+	if got := pragma(t, history, "user_version"); got != 3 {
+		t.Fatalf("expected user_version 3, got %d", got)
+	}
+
+	// The history table is still kept alongside.
+	db, err := sql.Open("sqlite", history)
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow(`select count(*) from migrate_schema_migrations`).Scan(&count); err != nil || count != 3 {
+		t.Fatalf("expected 3 history rows, got %d %v", count, err)
+	}
+}
+
+func TestSqliteUserVersionOrder(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Unpadded names sort 10_ before 2_, which must fail rather than skip 2_.
+	migrations := []struct{ name, sql string }{
+		{`10_create.sql`, `create table order_test (id integer)`},
+		{`2_insert.sql`, `insert into order_test values (2)`},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		dsn     string
+		history bool
+	}{
+		{"user_version", "file:" + dir + "/version.db", false},
+		{"history", "file:" + dir + "/history.db", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.history {
+				// Create the history table so it's kept alongside user_version.
+				migrator, err := driver.New(tt.dsn)
+				if err != nil {
+					t.Fatalf("connect %s", err)
+				}
+				if err = migrator.Begin(); err != nil {
+					t.Fatalf("begin %s", err)
+				}
+				if err = migrator.Commit(); err != nil {
+					t.Fatalf("commit %s", err)
+				}
+			}
+
+			migrator, err := driver.New(tt.dsn, driver.WithUserVersion())
+			if err != nil {
+				t.Fatalf("connect %s", err)
+			}
+			if err = migrator.Begin(); err != nil {
+				t.Fatalf("begin %s", err)
+			}
+			defer migrator.Rollback()
+
+			if err = migrator.Migrate(migrations[0].name, strings.NewReader(migrations[0].sql)); err != nil {
+				t.Fatalf("%s %s", migrations[0].name, err)
+			}
+			err = migrator.Migrate(migrations[1].name, strings.NewReader(migrations[1].sql))
+			if err == nil || !strings.Contains(err.Error(), "already been applied") {
+				t.Fatalf("expected out of order error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSqliteBackupRestore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := "sqlite:" + dir + "/migrate.db?x-backup=" + dir + "/backups&x-backup-keep=1"
+	migrations := []struct{ name, sql string }{
+		{`migrate backup: create`, `create table backup_test (id integer)`},
+		{`migrate backup: insert`, `insert into backup_test values (1)`},
+	}
+	// The last run applies nothing so takes no snapshot.
+	for _, migration := range append(migrations, migrations[1]) {
+		migrator, err := driver.New(dsn)
+		if err != nil {
+			t.Fatalf("connect %s", err)
+		}
+		if err = migrator.Begin(); err != nil {
+			t.Fatalf("begin %s", err)
+		}
+		if err = migrator.Migrate(migration.name, strings.NewReader(migration.sql)); err != nil {
+			t.Fatalf("%s %s", migration.name, err)
+		}
+		if err = migrator.Commit(); err != nil {
+			t.Fatalf("commit %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	backups, _ := os.ReadDir(dir + "/backups")
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup kept, got %d", len(backups))
+	}
+
+	migrator, err := driver.New(dsn)
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	if _, err = migrator.(mdriver.Restorer).Restore(); err != nil {
+		t.Fatalf("restore %s", err)
+	}
+
+	// Restored to just before the insert.
+	db, err := sql.Open("sqlite", dir+"/migrate.db")
+	if err != nil {
+		t.Fatalf("post restore connect %s", err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow(`select count(*) from backup_test`).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("expected empty table after restore, got %d %v", count, err)
+	}
+}
+
+func TestSqliteLoad(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	migrator, err := driver.New(dir + "/migrate.db")
+	if err != nil {
+		t.Fatalf("connect %s", err)
+	}
+	if err = migrator.Begin(); err != nil {
+		t.Fatalf("begin %s", err)
+	}
+
+	schema := `create table countries (code text primary key, name text, population integer, tags text default '[]')`
+	if err = migrator.Migrate("001-schema.sql", strings.NewReader(schema)); err != nil {
+		t.Fatalf("schema %s", err)
+	}
+
+	var rows strings.Builder
+	rows.WriteString("code,name,population\n")
+	for i := range 1000 {
+		fmt.Fprintf(&rows, "C%d,\"Country, %d\",%d\n", i, i, i*10)
+	}
+	loader := migrator.(mdriver.Loader)
+	if err = loader.Load("002-countries.csv", strings.NewReader(rows.String()), mdriver.Data{Table: "countries", Format: mdriver.CSV}); err != nil {
+		t.Fatalf("load csv %s", err)
+	}
+
+	ndjson := `{"code": "NZ", "name": "New Zealand", "tags": ["pacific"]}` + "\n" + `{"code": "AU", "population": 27000000}` + "\n"
+	if err = loader.Load("003-countries.ndjson", strings.NewReader(ndjson), mdriver.Data{Table: "countries", Format: mdriver.NDJSON}); err != nil {
+		t.Fatalf("load ndjson %s", err)
+	}
+
+	// Already loaded so skipped.
+	if err = loader.Load("003-countries.ndjson", strings.NewReader(ndjson), mdriver.Data{Table: "countries", Format: mdriver.NDJSON}); err != nil {
+		t.Fatalf("reload ndjson %s", err)
+	}
+	// Errors give the line, counting blank ones.
+	if err = loader.Load("004-countries.ndjson", strings.NewReader("\n{\"code\": \"FJ\",}\n"), mdriver.Data{Table: "countries", Format: mdriver.NDJSON}); err == nil || !strings.Contains(err.Error(), "ndjson line 2") {
+		t.Errorf("expected ndjson line 2 error, got %v", err)
+	}
+	if err = loader.Load("004-sales.parquet", strings.NewReader(""), mdriver.Data{Table: "sales", Format: mdriver.Parquet}); !errors.Is(err, mdriver.ErrUnsupported) {
+		t.Errorf("expected unsupported parquet, got %v", err)
+	}
+
+	if err = migrator.Commit(); err != nil {
+		t.Fatalf("commit %s", err)
+	}
+
+	db, err := sql.Open("sqlite", dir+"/migrate.db")
+	if err != nil {
+		t.Fatalf("post migrate connect %s", err)
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow(`select count(*) from countries`).Scan(&count); err != nil || count != 1002 {
+		t.Errorf("expected 1002 countries, got %d %v", count, err)
+	}
+	var name string
+	var population int
+	if err := db.QueryRow(`select name, population from countries where code = 'C7'`).Scan(&name, &population); err != nil || name != "Country, 7" || population != 70 {
+		t.Errorf("expected csv row, got %q %d %v", name, population, err)
+	}
+	var tags string
+	if err := db.QueryRow(`select tags from countries where code = 'NZ'`).Scan(&tags); err != nil || tags != `["pacific"]` {
+		t.Errorf("expected ndjson tags, got %q %v", tags, err)
+	}
+	if err := db.QueryRow(`select tags from countries where code = 'AU'`).Scan(&tags); err != nil || tags != `[]` {
+		t.Errorf("expected default tags, got %q %v", tags, err)
+	}
+}
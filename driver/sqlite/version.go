@@ -0,0 +1,162 @@
+package sqlite
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/shanna/migrate/driver"
+)
+
+// WithUserVersion tracks migrations in PRAGMA user_version instead of a
+// history table, as apps sharing a database file with other tools often do.
+// Each migration name must start with its version number, such as
+// 0003_add_users.sql; those at or below user_version are skipped and
+// user_version is set to each version as it runs. A migration that hasn't run
+// with a version at or below one that has fails the run, so pad version
+// numbers to keep names in version order. Also set with the
+// x-user-version=true DSN parameter.
+//
+// An existing history table is kept and still written to so both can be used
+// side by side. When user_version is still 0 it starts from the highest
+// version the history table records.
+func WithUserVersion() driver.Option {
+	return option(func(o *options) {
+		o.userVersion = true
+	})
+}
+
+// WithApplicationID checks PRAGMA application_id is id before migrating so a
+// database file belonging to another application is never touched. A new
+// database is given the id. Also set with the x-application-id DSN parameter.
+func WithApplicationID(id int32) driver.Option {
+	return option(func(o *options) {
+		o.applicationID = id
+	})
+}
+
+var versionRe = regexp.MustCompile(`^\d+`)
+
+// migrationVersion returns the version a migration name starts with.
+func migrationVersion(name string) (int32, error) {
+	match := versionRe.FindString(name)
+	if match == "" {
+		return 0, fmt.Errorf("%q has no leading version number for user_version", name)
+	}
+	version, err := strconv.ParseInt(match, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q version: %w", name, err)
+	}
+	return int32(version), nil
+}
+
+// versionSchema is the database holding user_version and application_id.
+func (s *Sqlite) versionSchema() string {
+	if s.schemaAttached {
+		return quote(s.schema)
+	}
+	return "main"
+}
+
+// historyExists reports whether the history table has been created.
+func (s *Sqlite) historyExists() (bool, error) {
+	schema, table := "main", s.qualifiedTableName()
+	if s.schemaAttached {
+		schema, table = quote(s.schema), s.tableName
+	}
+	var count int
+	err := s.db.QueryRow(fmt.Sprintf(`select count(*) from %s.sqlite_master where type = 'table' and name = ?`, schema), table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("history table: %w", err)
+	}
+	return count > 0, nil
+}
+
+// setupUserVersion reads user_version inside the run's transaction, seeding it
+// from the history table the first time.
+func (s *Sqlite) setupUserVersion() error {
+	if err := s.db.QueryRow(fmt.Sprintf(`pragma %s.user_version`, s.versionSchema())).Scan(&s.version); err != nil {
+		return fmt.Errorf("user_version: %w", err)
+	}
+	s.versions = map[int32]string{}
+	s.recorded = 0
+
+	if s.history {
+		highest, err := s.recordedVersion()
+		if err != nil {
+			return err
+		}
+		s.recorded = highest
+
+		if s.version == 0 && highest != 0 {
+			s.logger.Info("migrate user_version from history", "driver", "sqlite", "user_version", highest)
+			if err := s.setUserVersion(highest); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.startVersion = s.version
+	return nil
+}
+
+// recordedVersion returns the highest version in the history table.
+func (s *Sqlite) recordedVersion() (int32, error) {
+	rows, err := s.db.Query(fmt.Sprintf(`select name from %s`, s.qualifiedTableName()))
+	if err != nil {
+		return 0, fmt.Errorf("schema_migrations select %s", err)
+	}
+	defer rows.Close()
+
+	var highest int32
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return 0, fmt.Errorf("schema_migrations scan %s", err)
+		}
+		if version, err := migrationVersion(name); err == nil && version > highest {
+			highest = version
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("schema_migrations select %s", err)
+	}
+	return highest, nil
+}
+
+// outOfOrder fails a migration that hasn't run but whose version is at or
+// below one that has, as happens when unpadded names such as 10_x.sql sort
+// before 2_x.sql. Skipping it would silently never run it.
+func outOfOrder(name string, version, applied int32) error {
+	return fmt.Errorf("%q has version %d but version %d has already been applied; pad version numbers so names sort in version order", name, version, applied)
+}
+
+func (s *Sqlite) setUserVersion(version int32) error {
+	if _, err := s.db.Exec(fmt.Sprintf(`pragma %s.user_version = %d`, s.versionSchema(), version)); err != nil {
+		return fmt.Errorf("user_version: %w", err)
+	}
+	s.version = version
+	return nil
+}
+
+// checkApplicationID fails if the database belongs to another application,
+// claiming a database that has no id yet.
+func (s *Sqlite) checkApplicationID() error {
+	if s.applicationID == 0 {
+		return nil
+	}
+	var id int32
+	if err := s.db.QueryRow(fmt.Sprintf(`pragma %s.application_id`, s.versionSchema())).Scan(&id); err != nil {
+		return fmt.Errorf("application_id: %w", err)
+	}
+	switch id {
+	case s.applicationID:
+		return nil
+	case 0:
+		if _, err := s.db.Exec(fmt.Sprintf(`pragma %s.application_id = %d`, s.versionSchema(), s.applicationID)); err != nil {
+			return fmt.Errorf("application_id: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("application_id is %d not %d, the database belongs to another application", id, s.applicationID)
+}
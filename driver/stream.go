@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"crypto/sha512"
+	"hash"
+	"io"
+
+	"github.com/shanna/migrate/statement"
+)
+
+// Stream splits a migration into statements as it is read so drivers can
+// execute each statement as it arrives rather than holding the whole
+// migration in memory. Everything read is hashed for the history table.
+type Stream struct {
+	*statement.Scanner
+	reader   io.Reader
+	checksum hash.Hash
+}
+
+// NewStream reads statements in dialect from data.
+func NewStream(data io.Reader, dialect statement.Dialect) *Stream {
+	checksum := sha512.New()
+	reader := io.TeeReader(data, checksum)
+	return &Stream{
+		Scanner:  statement.NewScanner(reader, dialect),
+		reader:   reader,
+		checksum: checksum,
+	}
+}
+
+// Checksum drains anything left unread and returns the SHA-512 of the whole
+// migration.
+func (s *Stream) Checksum() ([]byte, error) {
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, s.reader); err != nil {
+		return nil, err
+	}
+	return s.checksum.Sum(nil), nil
+}
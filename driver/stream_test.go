@@ -0,0 +1,44 @@
+package driver_test
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"strings"
+	"testing"
+
+	"github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
+)
+
+func TestStreamChecksum(t *testing.T) {
+	sql := "select 1;\nselect 2;\n-- trailing comment\n"
+	want := sha512.Sum512([]byte(sql))
+
+	var tests = []struct {
+		name  string
+		reads int
+	}{
+		{`unread`, 0},
+		{`partially read`, 1},
+		{`fully read`, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stream := driver.NewStream(strings.NewReader(sql), statement.Postgres)
+			for range test.reads {
+				if !stream.Scan() {
+					t.Fatal("expected statement")
+				}
+			}
+
+			checksum, err := stream.Checksum()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(want[:], checksum) {
+				t.Errorf("checksum mismatch")
+			}
+		})
+	}
+}
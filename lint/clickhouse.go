@@ -0,0 +1,87 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var clickhouseRules = []Rule{
+	{
+		ID:       "CH001",
+		Severity: Warning,
+		Summary:  "UPDATE and DELETE are asynchronous mutations that rewrite whole data parts.",
+		Check:    clickhouseMutation,
+	},
+	{
+		ID:       "CH002",
+		Severity: Warning,
+		Summary:  "MATERIALIZE and CLEAR are mutations that rewrite every data part of the table.",
+		Check:    clickhouseMaterialize,
+	},
+	{
+		ID:       "CH003",
+		Severity: Warning,
+		Summary:  "MODIFY COLUMN with a new type is a mutation that rewrites the column in every data part.",
+		Check:    clickhouseModifyColumn,
+	},
+}
+
+var (
+	clickhouseAlterTableRe  = regexp.MustCompile(`^alter table (?:if exists )?([\pL\pN_.]+) (?:on cluster [\pL\pN_.{}'-]+ )?(.*)$`)
+	clickhouseDeleteRe      = regexp.MustCompile(`^delete from ([\pL\pN_.]+)`)
+	clickhouseMutationRe    = regexp.MustCompile(`^(update|delete) `)
+	clickhouseMaterializeRe = regexp.MustCompile(`^(materialize|clear) (index|column|projection|statistics|ttl)\b`)
+	clickhouseModifyRe      = regexp.MustCompile(`^modify column (?:if exists )?([\pL\pN_]+) ([\pL\pN_]+)`)
+	clickhouseNotTypeRe     = regexp.MustCompile(`^(?:comment|default|materialized|alias|ephemeral|codec|ttl|remove|modify|reset|settings|first|after)$`)
+)
+
+func clickhouseMutation(state *State, stmt Statement) []string {
+	if match := clickhouseDeleteRe.FindStringSubmatch(stmt.SQL); match != nil {
+		return []string{fmt.Sprintf("lightweight delete on %q is a mutation that runs asynchronously", match[1])}
+	}
+
+	table, actions := alterTable(clickhouseAlterTableRe, stmt.SQL)
+	if table == "" || state.Created[table] {
+		return nil
+	}
+
+	var messages []string
+	for _, action := range actions {
+		if match := clickhouseMutationRe.FindStringSubmatch(action); match != nil {
+			messages = append(messages, fmt.Sprintf("%s on %q is a mutation that rewrites data parts asynchronously", match[1], table))
+		}
+	}
+	return messages
+}
+
+func clickhouseMaterialize(state *State, stmt Statement) []string {
+	table, actions := alterTable(clickhouseAlterTableRe, stmt.SQL)
+	if table == "" || state.Created[table] {
+		return nil
+	}
+
+	var messages []string
+	for _, action := range actions {
+		if match := clickhouseMaterializeRe.FindStringSubmatch(action); match != nil {
+			messages = append(messages, fmt.Sprintf("%s %s on %q is a mutation that rewrites every data part", match[1], match[2], table))
+		}
+	}
+	return messages
+}
+
+func clickhouseModifyColumn(state *State, stmt Statement) []string {
+	table, actions := alterTable(clickhouseAlterTableRe, stmt.SQL)
+	if table == "" || state.Created[table] {
+		return nil
+	}
+
+	var messages []string
+	for _, action := range actions {
+		match := clickhouseModifyRe.FindStringSubmatch(action)
+		if match == nil || clickhouseNotTypeRe.MatchString(match[2]) {
+			continue
+		}
+		messages = append(messages, fmt.Sprintf("changing the type of %q.%q rewrites the column in every data part", table, match[1]))
+	}
+	return messages
+}
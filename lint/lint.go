@@ -0,0 +1,290 @@
+// Package lint inspects SQL migrations for operations that are dangerous to
+// run against a live database.
+//
+// Rules are driver specific. A statement can opt out of one or more rules with
+// an inline comment:
+//
+//	-- migrate:lint-ignore PG002
+//	create index users_email_idx on users (email);
+//
+// Without rule IDs the comment suppresses every rule for that statement.
+package lint
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	mdriver "github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/statement"
+)
+
+// Severity of a finding.
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// ParseSeverity parses the String form of a Severity.
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "info":
+		return Info, nil
+	case "warning", "warn":
+		return Warning, nil
+	case "error":
+		return Error, nil
+	}
+	return 0, fmt.Errorf("unknown severity %q", s)
+}
+
+// Finding is a hazard reported by a rule.
+type Finding struct {
+	File     string
+	Line     int
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s %s: %s", f.File, f.Line, f.Rule, f.Severity, f.Message)
+}
+
+// Statement is a single SQL statement as seen by a rule.
+type Statement struct {
+	// SQL is the lower cased statement with comments removed, string literals
+	// blanked and whitespace collapsed.
+	SQL string
+	// Raw is the statement as written including leading comments.
+	Raw  string
+	Line int
+}
+
+// State is shared by rules across every statement in a lint run so rules can
+// take earlier statements into account.
+type State struct {
+	// Created holds tables created earlier in the same file. Locking hazards
+	// don't apply to a table nobody else can see yet.
+	Created map[string]bool
+	// NotNullChecks holds "table.column" pairs with a CHECK (column IS NOT NULL)
+	// constraint added by any earlier statement.
+	NotNullChecks map[string]bool
+	// ServerVersion is the major version of the target server, zero for a
+	// current one.
+	ServerVersion int
+}
+
+// Rule inspects a single statement.
+type Rule struct {
+	ID       string
+	Severity Severity
+	Summary  string
+	// Check returns a message for each hazard found in the statement.
+	Check func(state *State, stmt Statement) []string
+}
+
+type dialect struct {
+	dialect statement.Dialect
+	rules   []Rule
+}
+
+var dialects = map[string]dialect{
+	"postgres":   {statement.Postgres, postgresRules},
+	"sqlite":     {statement.SQLite, sqliteRules},
+	"clickhouse": {statement.ClickHouse, clickhouseRules},
+	"duckdb":     {statement.DuckDB, nil},
+}
+
+var aliases = map[string]string{
+	"pg":         "postgres",
+	"postgresql": "postgres",
+}
+
+func lookup(driver string) (dialect, error) {
+	if alias, ok := aliases[driver]; ok {
+		driver = alias
+	}
+	d, ok := dialects[driver]
+	if !ok {
+		return dialect{}, fmt.Errorf("unknown lint driver %q", driver)
+	}
+	return d, nil
+}
+
+// Rules returns the rules for a driver name.
+func Rules(driver string) ([]Rule, error) {
+	d, err := lookup(driver)
+	return d.rules, err
+}
+
+// Linter applies the rules for one driver to a series of migrations.
+type Linter struct {
+	dialect dialect
+	state   *State
+}
+
+// Option configures a Linter.
+type Option func(*Linter)
+
+// WithServerVersion lints for the major version of the target server, so
+// hazards fixed in later versions are only reported for older ones. Without
+// it a current server is assumed.
+func WithServerVersion(major int) Option {
+	return func(l *Linter) {
+		l.state.ServerVersion = major
+	}
+}
+
+// New creates a Linter for a driver name.
+func New(driver string, opts ...Option) (*Linter, error) {
+	d, err := lookup(driver)
+	if err != nil {
+		return nil, err
+	}
+	l := &Linter{
+		dialect: d,
+		state:   &State{NotNullChecks: map[string]bool{}},
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l, nil
+}
+
+// Lint the SQL read from data. Name is only used to label findings.
+func (l *Linter) Lint(name string, data io.Reader) ([]Finding, error) {
+	l.state.Created = map[string]bool{}
+
+	var findings []Finding
+	scanner := statement.NewScanner(data, l.dialect.dialect)
+	for scanner.Scan() {
+		stmt := normalize(scanner.Statement(), l.dialect.dialect)
+		ignored, all := ignores(stmt.Raw)
+		if all {
+			continue
+		}
+		for _, rule := range l.dialect.rules {
+			if ignored[rule.ID] {
+				continue
+			}
+			for _, message := range rule.Check(l.state, stmt) {
+				findings = append(findings, Finding{
+					File:     name,
+					Line:     stmt.Line,
+					Rule:     rule.ID,
+					Severity: rule.Severity,
+					Message:  message,
+				})
+			}
+		}
+		if match := createTableRe.FindStringSubmatch(stmt.SQL); match != nil {
+			l.state.Created[match[1]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return findings, nil
+}
+
+// Dir lints every plain file in dir in the same order migrate would run them.
+// Executable migrations are skipped since their SQL isn't known until they run,
+// as are data files since they hold no SQL.
+func Dir(driver, dir string, opts ...Option) ([]Finding, error) {
+	findings, err := FS(driver, os.DirFS(dir), ".", opts...)
+	for i := range findings {
+		findings[i].File = filepath.Join(dir, findings[i].File)
+	}
+	return findings, err
+}
+
+// FS lints every plain file in dir of fsys in the same order migrate would run them.
+func FS(driver string, fsys fs.FS, dir string, opts ...Option) ([]Finding, error) {
+	linter, err := New(driver, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("stat: %w", err)
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0100 != 0 {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if _, ok := mdriver.DataFormatOf(path); ok || mdriver.IsDataSidecar(path) {
+			continue
+		}
+
+		fh, err := fsys.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		found, err := linter.Lint(path, fh)
+		fh.Close()
+		if err != nil {
+			return nil, fmt.Errorf("lint %s: %w", path, err)
+		}
+		findings = append(findings, found...)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings, nil
+}
+
+var createTableRe = regexp.MustCompile(`^create (?:[a-z]+ )*?table (?:if not exists )?([\pL\pN_.]+)`)
+
+var ignoreRe = regexp.MustCompile(`(?m)--\s*migrate:lint-ignore\b([^\n]*)`)
+
+// ignores returns rule IDs suppressed by migrate:lint-ignore comments, or all
+// when a comment names no rules.
+func ignores(raw string) (ids map[string]bool, all bool) {
+	ids = map[string]bool{}
+	for _, match := range ignoreRe.FindAllStringSubmatch(raw, -1) {
+		fields := strings.FieldsFunc(match[1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		if len(fields) == 0 {
+			return ids, true
+		}
+		for _, id := range fields {
+			ids[strings.ToUpper(id)] = true
+		}
+	}
+	return ids, false
+}
@@ -0,0 +1,172 @@
+package lint_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shanna/migrate/lint"
+)
+
+func rules(t *testing.T, driver, sql string, opts ...lint.Option) []string {
+	t.Helper()
+
+	linter, err := lint.New(driver, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := linter.Lint("test.sql", strings.NewReader(sql))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, finding := range findings {
+		ids = append(ids, finding.Rule)
+	}
+	return ids
+}
+
+func TestLintPostgres(t *testing.T) {
+	var tests = []struct {
+		name, sql string
+		want      []string
+	}{
+		{`add column default`, `alter table users add column active boolean default true`, []string{}},
+		{`add column volatile default`, `ALTER TABLE users ADD COLUMN token uuid DEFAULT gen_random_uuid()`, []string{"PG001"}},
+		{`add column nullable`, `alter table users add column nickname text`, []string{}},
+		{`add column new table`, `create table users (id int); alter table users add column active boolean default true`, []string{}},
+		{`create index`, `create index users_email_idx on users (email)`, []string{"PG002"}},
+		{`create index new table`, `create table users (email text); create index users_email_idx on users (email)`, []string{}},
+		{`create index concurrently`, `create index concurrently users_email_idx on users (email)`, []string{"PG005"}},
+		{`set not null`, `alter table users alter column email set not null`, []string{"PG003"}},
+		{`set not null with check`, `alter table users add constraint users_email_nn check (email is not null) not valid; alter table users alter column email set not null`, []string{}},
+		{`alter column type`, `alter table users alter column id type bigint, alter column name set data type text`, []string{"PG004", "PG004"}},
+		{`ignore rule`, "-- migrate:lint-ignore PG002\ncreate index users_email_idx on users (email)", []string{}},
+		{`ignore other rule`, "-- migrate:lint-ignore PG001\ncreate index users_email_idx on users (email)", []string{"PG002"}},
+		{`ignore all`, "create index users_email_idx on users (email); -- migrate:lint-ignore\ncreate index users_name_idx on users (name)", []string{"PG002"}},
+		{`quoted`, `select 'create index x on y (z)'; select $$alter table users alter column id type bigint$$`, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, rules(t, "postgres", test.sql)); diff != "" {
+				t.Errorf("rules mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLintPostgresServerVersion(t *testing.T) {
+	var tests = []struct {
+		name, sql string
+		version   int
+		want      []string
+	}{
+		{`default 10`, `alter table users add column active boolean default true`, 10, []string{"PG001"}},
+		{`default 11`, `alter table users add column active boolean default true`, 11, []string{}},
+		{`volatile default 10`, `alter table users add column token uuid default gen_random_uuid()`, 10, []string{"PG001"}},
+		{`volatile default 11`, `alter table users add column token uuid default gen_random_uuid()`, 11, []string{"PG001"}},
+		{`serial 11`, `alter table users add column seq bigserial`, 11, []string{"PG001"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, rules(t, "postgres", test.sql, lint.WithServerVersion(test.version))); diff != "" {
+				t.Errorf("rules mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLintIdentifiers(t *testing.T) {
+	linter, err := lint.New("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sql := `alter table café alter column "Prix" type numeric; create table "Été" (id int); create index été_idx on été (id)`
+	findings, err := linter.Lint("test.sql", strings.NewReader(sql))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, finding := range findings {
+		messages = append(messages, finding.Message)
+	}
+	want := []string{`changing the type of "café"."prix" rewrites the table and its indexes under an ACCESS EXCLUSIVE lock`}
+	if diff := cmp.Diff(want, messages); diff != "" {
+		t.Errorf("messages mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLintSqlite(t *testing.T) {
+	var tests = []struct {
+		name, sql string
+		want      []string
+	}{
+		{`rename`, `alter table users rename to people`, []string{}},
+		{`add column`, `alter table users add column nickname text`, []string{}},
+		{`alter column`, `alter table users alter column email set not null`, []string{"SQ001"}},
+		{`multiple actions`, `alter table users add column a text, add column b text`, []string{"SQ001"}},
+		{`drop column`, `alter table users drop column nickname`, []string{"SQ002"}},
+		{`add column not null`, `alter table users add column email text not null`, []string{"SQ003"}},
+		{`add column not null default`, `alter table users add column email text not null default ''`, []string{}},
+		{`add column expression default`, `alter table users add column created datetime default current_timestamp`, []string{"SQ003"}},
+		{`add column unique`, `alter table users add column email text unique`, []string{"SQ003"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, rules(t, "sqlite", test.sql)); diff != "" {
+				t.Errorf("rules mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLintClickHouse(t *testing.T) {
+	var tests = []struct {
+		name, sql string
+		want      []string
+	}{
+		{`update`, `ALTER TABLE events UPDATE name = 'x' WHERE id = 1`, []string{"CH001"}},
+		{`delete`, `ALTER TABLE events ON CLUSTER main DELETE WHERE id = 1`, []string{"CH001"}},
+		{`lightweight delete`, `DELETE FROM events WHERE id = 1`, []string{"CH001"}},
+		{`materialize`, `ALTER TABLE events MATERIALIZE INDEX events_name_idx`, []string{"CH002"}},
+		{`modify type`, `ALTER TABLE events MODIFY COLUMN id UInt64`, []string{"CH003"}},
+		{`modify comment`, `ALTER TABLE events MODIFY COLUMN id COMMENT 'identifier'`, []string{}},
+		{`add column`, `ALTER TABLE events ADD COLUMN name String`, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, rules(t, "clickhouse", test.sql)); diff != "" {
+				t.Errorf("rules mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLintLine(t *testing.T) {
+	linter, err := lint.New("pg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sql := "create table a (id int);\n\n/* comment\n   spanning lines */\ncreate index b_idx\n  on b (id);\n"
+	findings, err := linter.Lint("test.sql", strings.NewReader(sql))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Line != 5 {
+		t.Fatalf("expected one finding on line 5, got %v", findings)
+	}
+}
+
+func TestLintUnknownDriver(t *testing.T) {
+	if _, err := lint.New("oracle"); err == nil {
+		t.Fatal("expected unknown driver error")
+	}
+}
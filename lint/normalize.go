@@ -0,0 +1,153 @@
+package lint
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shanna/migrate/statement"
+)
+
+// normalize a statement for matching by rules. Comments are dropped, string
+// and dollar quoted literals blanked, quoted identifiers unquoted and
+// whitespace collapsed. Line is moved past any leading comments.
+func normalize(stmt statement.Statement, dialect statement.Dialect) Statement {
+	var (
+		sql   = stmt.SQL
+		norm  strings.Builder
+		line  = stmt.Line
+		first = 0
+		space = false
+	)
+
+	token := func(s string) {
+		if first == 0 {
+			first = line
+		}
+		if space && norm.Len() > 0 {
+			norm.WriteByte(' ')
+		}
+		space = false
+		norm.WriteString(s)
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-', c == '#' && dialect == statement.ClickHouse:
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			i += end
+			space = true
+
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			depth, j := 1, i+2
+			for j < len(sql) && depth > 0 {
+				switch {
+				case sql[j] == '/' && j+1 < len(sql) && sql[j+1] == '*':
+					depth++
+					j += 2
+				case sql[j] == '*' && j+1 < len(sql) && sql[j+1] == '/':
+					depth--
+					j += 2
+				default:
+					j++
+				}
+			}
+			line += strings.Count(sql[i:j], "\n")
+			i = j
+			space = true
+
+		case c == '\'':
+			escapes := dialect == statement.ClickHouse || i > 0 && (sql[i-1] == 'e' || sql[i-1] == 'E')
+			j := i + 1
+			for j < len(sql) {
+				if escapes && sql[j] == '\\' {
+					j += 2
+					continue
+				}
+				if sql[j] == '\'' {
+					if j+1 < len(sql) && sql[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			j = min(j+1, len(sql))
+			token("''")
+			line += strings.Count(sql[i:j], "\n")
+			i = j
+
+		case c == '"' || c == '`':
+			// A doubled quote is an escaped quote, as is a backslash escape in
+			// ClickHouse.
+			var name strings.Builder
+			j := i + 1
+			for j < len(sql) {
+				if dialect == statement.ClickHouse && sql[j] == '\\' && j+1 < len(sql) {
+					name.WriteByte(sql[j+1])
+					j += 2
+					continue
+				}
+				if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c {
+						name.WriteByte(c)
+						j += 2
+						continue
+					}
+					j++
+					break
+				}
+				name.WriteByte(sql[j])
+				j++
+			}
+			token(strings.ToLower(name.String()))
+			line += strings.Count(sql[i:j], "\n")
+			i = j
+
+		case c == '$' && dollarTag(sql[i:]) != "":
+			tag := dollarTag(sql[i:])
+			end := strings.Index(sql[i+len(tag):], tag)
+			j := len(sql)
+			if end >= 0 {
+				j = i + len(tag) + end + len(tag)
+			}
+			token("''")
+			line += strings.Count(sql[i:j], "\n")
+			i = j
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if c == '\n' {
+				line++
+			}
+			space = true
+			i++
+
+		default:
+			r, size := utf8.DecodeRuneInString(sql[i:])
+			token(strings.ToLower(string(r)))
+			i += size
+		}
+	}
+
+	return Statement{SQL: norm.String(), Raw: sql, Line: first}
+}
+
+// dollarTag returns the opening $tag$ of a dollar quoted string or "".
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9':
+			continue
+		default:
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,28 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/shanna/migrate/statement"
+)
+
+func TestNormalize(t *testing.T) {
+	var tests = []struct {
+		name, sql, want string
+		dialect         statement.Dialect
+	}{
+		{`utf-8`, `CREATE TABLE Café (Prix int)`, `create table café (prix int)`, statement.Postgres},
+		{`quoted utf-8`, `create table "Été" (id int)`, `create table été (id int)`, statement.Postgres},
+		{`doubled quote`, `alter table "a""b" add column c int`, `alter table a"b add column c int`, statement.Postgres},
+		{`backtick escape`, "alter table `a\\`b` delete where 1", "alter table a`b delete where 1", statement.ClickHouse},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := normalize(statement.Statement{SQL: test.sql, Line: 1}, test.dialect)
+			if got.SQL != test.want {
+				t.Errorf("expected %q, got %q", test.want, got.SQL)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var postgresRules = []Rule{
+	{
+		ID:       "PG001",
+		Severity: Warning,
+		Summary:  "ADD COLUMN with a DEFAULT rewrites the table on PostgreSQL < 11, or on any version with a volatile default.",
+		Check:    pgAddColumnDefault,
+	},
+	{
+		ID:       "PG002",
+		Severity: Warning,
+		Summary:  "CREATE INDEX without CONCURRENTLY blocks writes to the table until the index is built.",
+		Check:    pgCreateIndex,
+	},
+	{
+		ID:       "PG003",
+		Severity: Warning,
+		Summary:  "SET NOT NULL scans the whole table under an ACCESS EXCLUSIVE lock unless a validated CHECK constraint exists.",
+		Check:    pgSetNotNull,
+	},
+	{
+		ID:       "PG004",
+		Severity: Warning,
+		Summary:  "Changing a column type rewrites the table and its indexes under an ACCESS EXCLUSIVE lock.",
+		Check:    pgAlterColumnType,
+	},
+	{
+		ID:       "PG005",
+		Severity: Error,
+		Summary:  "CONCURRENTLY cannot run inside the transaction migrations are applied in.",
+		Check:    pgConcurrently,
+	},
+}
+
+var (
+	pgAlterTableRe  = regexp.MustCompile(`^alter table (?:if exists )?(?:only )?([\pL\pN_.]+) (.*)$`)
+	pgCreateIndexRe = regexp.MustCompile(`^create (?:unique )?index (concurrently )?(?:if not exists )?(?:[\pL\pN_.]+ )?on (?:only )?([\pL\pN_.]+)`)
+	pgAddColumnRe   = regexp.MustCompile(`^add (?:column )?(?:if not exists )?([\pL\pN_]+) (.*)$`)
+	pgDefaultRe     = regexp.MustCompile(`\bdefault\b`)
+	pgVolatileRe    = regexp.MustCompile(`\b(?:random|gen_random_uuid|uuid_generate_v[14]|clock_timestamp|timeofday|nextval) ?\(|^(?:small|big)?serial\b`)
+	pgSetNotNullRe  = regexp.MustCompile(`^alter (?:column )?([\pL\pN_]+) set not null\b`)
+	pgNotNullRe     = regexp.MustCompile(`^add (?:constraint [\pL\pN_]+ )?check ?\( ?([\pL\pN_]+) is not null ?\)`)
+	pgTypeRe        = regexp.MustCompile(`^alter (?:column )?([\pL\pN_]+) (?:set data )?type\b`)
+	pgConcurrentRe  = regexp.MustCompile(`^(?:create (?:unique )?index|drop index|reindex (?:\(.*\) )?(?:index|table|schema|database|system)) concurrently\b`)
+)
+
+// alterTable returns the table and top level actions of an ALTER TABLE statement.
+func alterTable(re *regexp.Regexp, sql string) (string, []string) {
+	match := re.FindStringSubmatch(sql)
+	if match == nil {
+		return "", nil
+	}
+	var actions []string
+	depth, start := 0, 0
+	body := match[2]
+	for i, c := range body {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				actions = append(actions, strings.TrimSpace(body[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	actions = append(actions, strings.TrimSpace(body[start:]))
+	return match[1], actions
+}
+
+func pgAddColumnDefault(state *State, stmt Statement) []string {
+	table, actions := alterTable(pgAlterTableRe, stmt.SQL)
+	if state.Created[table] {
+		return nil
+	}
+
+	var messages []string
+	for _, action := range actions {
+		match := pgAddColumnRe.FindStringSubmatch(action)
+		if match == nil {
+			continue
+		}
+		switch {
+		case pgVolatileRe.MatchString(match[2]):
+			messages = append(messages, fmt.Sprintf("adding column %q to %q with a volatile default rewrites the whole table under an ACCESS EXCLUSIVE lock", match[1], table))
+		case pgDefaultRe.MatchString(match[2]) && state.ServerVersion > 0 && state.ServerVersion < 11:
+			messages = append(messages, fmt.Sprintf("adding column %q to %q with a default rewrites the whole table under an ACCESS EXCLUSIVE lock on PostgreSQL < 11", match[1], table))
+		}
+	}
+	return messages
+}
+
+func pgCreateIndex(state *State, stmt Statement) []string {
+	match := pgCreateIndexRe.FindStringSubmatch(stmt.SQL)
+	if match == nil || match[1] != "" || state.Created[match[2]] {
+		return nil
+	}
+	return []string{fmt.Sprintf("creating an index on %q blocks writes until it is built; consider building it CONCURRENTLY outside of migrate", match[2])}
+}
+
+func pgSetNotNull(state *State, stmt Statement) []string {
+	table, actions := alterTable(pgAlterTableRe, stmt.SQL)
+	if table == "" {
+		return nil
+	}
+
+	var messages []string
+	for _, action := range actions {
+		if match := pgNotNullRe.FindStringSubmatch(action); match != nil {
+			state.NotNullChecks[table+"."+match[1]] = true
+			continue
+		}
+		match := pgSetNotNullRe.FindStringSubmatch(action)
+		if match == nil || state.Created[table] || state.NotNullChecks[table+"."+match[1]] {
+			continue
+		}
+		messages = append(messages, fmt.Sprintf("setting %q.%q not null scans the table under an ACCESS EXCLUSIVE lock; add and validate a CHECK (%s IS NOT NULL) NOT VALID constraint in an earlier migration", table, match[1], match[1]))
+	}
+	return messages
+}
+
+func pgAlterColumnType(state *State, stmt Statement) []string {
+	table, actions := alterTable(pgAlterTableRe, stmt.SQL)
+	if table == "" || state.Created[table] {
+		return nil
+	}
+
+	var messages []string
+	for _, action := range actions {
+		if match := pgTypeRe.FindStringSubmatch(action); match != nil {
+			messages = append(messages, fmt.Sprintf("changing the type of %q.%q rewrites the table and its indexes under an ACCESS EXCLUSIVE lock", table, match[1]))
+		}
+	}
+	return messages
+}
+
+func pgConcurrently(state *State, stmt Statement) []string {
+	if !pgConcurrentRe.MatchString(stmt.SQL) {
+		return nil
+	}
+	return []string{"CONCURRENTLY cannot run inside a transaction block and every migration runs inside one"}
+}
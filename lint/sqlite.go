@@ -0,0 +1,88 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var sqliteRules = []Rule{
+	{
+		ID:       "SQ001",
+		Severity: Error,
+		Summary:  "SQLite ALTER TABLE only supports RENAME, ADD COLUMN and DROP COLUMN, one action per statement.",
+		Check:    sqliteUnsupportedAlter,
+	},
+	{
+		ID:       "SQ002",
+		Severity: Warning,
+		Summary:  "DROP COLUMN needs SQLite 3.35 and fails for indexed, key, constrained or referenced columns.",
+		Check:    sqliteDropColumn,
+	},
+	{
+		ID:       "SQ003",
+		Severity: Error,
+		Summary:  "ADD COLUMN cannot be PRIMARY KEY or UNIQUE, NOT NULL needs a default and the default must be constant.",
+		Check:    sqliteAddColumn,
+	},
+}
+
+var (
+	sqliteAlterTableRe = regexp.MustCompile(`^alter table ([\pL\pN_.]+) (.*)$`)
+	sqliteSupportedRe  = regexp.MustCompile(`^(?:rename to |rename (?:column )?[\pL\pN_]+ to |add (?:column )?|drop (?:column )?)`)
+	sqliteDropColumnRe = regexp.MustCompile(`^drop (?:column )?([\pL\pN_]+)$`)
+	sqliteAddColumnRe  = regexp.MustCompile(`^add (?:column )?([\pL\pN_]+)(.*)$`)
+	sqliteKeyRe        = regexp.MustCompile(`\b(?:primary key|unique)\b`)
+	sqliteNotNullRe    = regexp.MustCompile(`\bnot null\b`)
+	sqliteDefaultRe    = regexp.MustCompile(`\bdefault\b ?(\(|current_(?:time|date|timestamp)\b)?`)
+)
+
+func sqliteUnsupportedAlter(state *State, stmt Statement) []string {
+	table, actions := alterTable(sqliteAlterTableRe, stmt.SQL)
+	if table == "" {
+		return nil
+	}
+	if len(actions) > 1 {
+		return []string{fmt.Sprintf("altering %q with %d actions in one statement is not supported; use one ALTER TABLE per action", table, len(actions))}
+	}
+	if sqliteSupportedRe.MatchString(actions[0]) {
+		return nil
+	}
+	return []string{fmt.Sprintf("altering %q with %q is not supported; rebuild the table instead", table, actions[0])}
+}
+
+func sqliteDropColumn(state *State, stmt Statement) []string {
+	table, actions := alterTable(sqliteAlterTableRe, stmt.SQL)
+	if len(actions) != 1 {
+		return nil
+	}
+	match := sqliteDropColumnRe.FindStringSubmatch(actions[0])
+	if match == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf("dropping %q.%q fails if the column is indexed, part of a key or constraint, or used by a view or trigger", table, match[1])}
+}
+
+func sqliteAddColumn(state *State, stmt Statement) []string {
+	table, actions := alterTable(sqliteAlterTableRe, stmt.SQL)
+	if len(actions) != 1 {
+		return nil
+	}
+	match := sqliteAddColumnRe.FindStringSubmatch(actions[0])
+	if match == nil {
+		return nil
+	}
+
+	column, definition := match[1], match[2]
+	var messages []string
+	if sqliteKeyRe.MatchString(definition) {
+		messages = append(messages, fmt.Sprintf("adding %q.%q as PRIMARY KEY or UNIQUE is not supported", table, column))
+	}
+	def := sqliteDefaultRe.FindStringSubmatch(definition)
+	switch {
+	case def == nil && sqliteNotNullRe.MatchString(definition):
+		messages = append(messages, fmt.Sprintf("adding NOT NULL column %q.%q requires a non-null default", table, column))
+	case def != nil && def[1] != "":
+		messages = append(messages, fmt.Sprintf("adding %q.%q with a non-constant default is not supported", table, column))
+	}
+	return messages
+}
@@ -2,6 +2,7 @@ package migrate // import "github.com/shanna/migrate"
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"path/filepath"
 
 	mdriver "github.com/shanna/migrate/driver"
+	"github.com/shanna/migrate/lint"
 )
 
 const ModeExecutable os.FileMode = 0100
@@ -34,6 +36,19 @@ type Migrate struct {
 }
 
 func New(driver, dsn string, opts ...Option) (*Migrate, error) {
+	migrator, err := mdriver.New(driver, dsn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithMigrator(migrator, opts...), nil
+}
+
+// NewWithMigrator uses an already constructed driver, such as one built with
+// postgres.NewFromConn around a connection the application owns. Options only
+// configure logging and naming here; driver options belong to the driver's
+// constructor.
+func NewWithMigrator(migrator mdriver.Migrator, opts ...Option) *Migrate {
 	// Build config with defaults to extract logger/nameFunc.
 	config := &mdriver.Config{
 		Schema:    mdriver.DefaultSchema,
@@ -45,16 +60,11 @@ func New(driver, dsn string, opts ...Option) (*Migrate, error) {
 		opt(config)
 	}
 
-	migrator, err := mdriver.New(driver, dsn, opts...)
-	if err != nil {
-		return nil, err
-	}
-
 	return &Migrate{
 		migrator: migrator,
 		nameFunc: config.NameFunc,
 		logger:   config.Logger,
-	}, nil
+	}
 }
 
 func (m *Migrate) DirFS(fsys fs.FS, dir string) error {
@@ -105,12 +115,25 @@ func (m *Migrate) DirFS(fsys fs.FS, dir string) error {
 			}
 
 		case mode.IsRegular():
+			if mdriver.IsDataSidecar(path) {
+				continue
+			}
+			data, ok, err := mdriver.DataFile(fsys, path)
+			if err != nil {
+				return err
+			}
+
 			m.logger.Debug(fmt.Sprintf("migrate read %s", path))
 			fh, err := fsys.Open(path)
 			if err != nil {
 				return err
 			}
-			if err := m.migrator.Migrate(m.nameFunc(path), fh); err != nil {
+			if ok {
+				err = m.load(m.nameFunc(path), fh, data)
+			} else {
+				err = m.migrator.Migrate(m.nameFunc(path), fh)
+			}
+			if err != nil {
 				return err
 			}
 		}
@@ -145,8 +168,21 @@ func (m *Migrate) Dir(dir string) error {
 				return err
 			}
 		case mode.IsRegular():
+			if mdriver.IsDataSidecar(path) {
+				continue
+			}
+			data, ok, err := mdriver.DataFile(os.DirFS(dir), entry.Name())
+			if err != nil {
+				return err
+			}
+
 			m.logger.Debug(fmt.Sprintf("migrate read %s", path))
-			if err := m.open(path); err != nil {
+			if ok {
+				err = m.openData(path, data)
+			} else {
+				err = m.open(path)
+			}
+			if err != nil {
 				return err
 			}
 		}
@@ -180,3 +216,133 @@ func (m *Migrate) open(path string) error {
 	}
 	return m.migrator.Migrate(m.nameFunc(path), fh)
 }
+
+func (m *Migrate) openData(path string, data mdriver.Data) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return m.load(m.nameFunc(path), fh, data)
+}
+
+// load a data file migration into its table.
+func (m *Migrate) load(name string, fh io.Reader, data mdriver.Data) error {
+	loader, ok := m.migrator.(mdriver.Loader)
+	if !ok {
+		return fmt.Errorf("load %s: %w", name, mdriver.ErrUnsupported)
+	}
+	return loader.Load(name, fh, data)
+}
+
+// LockInfo returns the holder of the driver's database migration lock.
+func (m *Migrate) LockInfo() (mdriver.LockInfo, error) {
+	locker, ok := m.migrator.(mdriver.Locker)
+	if !ok {
+		return mdriver.LockInfo{}, fmt.Errorf("lock info: %w", mdriver.ErrUnsupported)
+	}
+	defer m.close()
+	return locker.LockInfo()
+}
+
+// BreakLock forcibly releases the driver's database migration lock after a
+// crashed run. Make sure the holder is really gone first.
+func (m *Migrate) BreakLock() error {
+	locker, ok := m.migrator.(mdriver.Locker)
+	if !ok {
+		return fmt.Errorf("break lock: %w", mdriver.ErrUnsupported)
+	}
+	defer m.close()
+	return locker.BreakLock()
+}
+
+// Resolve marks the dirty migration name in dir completed once the database
+// has been repaired by hand. Executable migrations can't be resolved since
+// their output isn't known without running them again; Clear them instead.
+func (m *Migrate) Resolve(dir, name string) error {
+	// Named from the same path as Dir gives the name function.
+	return m.resolve(os.DirFS(dir), ".", name, func(path string) string {
+		return m.nameFunc(filepath.Join(dir, path))
+	})
+}
+
+// ResolveFS marks the dirty migration name in dir of fsys completed.
+func (m *Migrate) ResolveFS(fsys fs.FS, dir, name string) error {
+	return m.resolve(fsys, dir, name, m.nameFunc)
+}
+
+// resolve the migration in dir of fsys whose path nameFunc names name.
+func (m *Migrate) resolve(fsys fs.FS, dir, name string, nameFunc func(string) string) error {
+	resolver, ok := m.migrator.(mdriver.Resolver)
+	if !ok {
+		return fmt.Errorf("resolve: %w", mdriver.ErrUnsupported)
+	}
+	defer m.close()
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || nameFunc(path) != name {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("stat: %w", err)
+		}
+		if info.Mode().Perm()&ModeExecutable != 0 {
+			return fmt.Errorf("resolve %s: executable migrations can only be cleared", name)
+		}
+
+		fh, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		return resolver.Resolve(name, fh)
+	}
+	return fmt.Errorf("resolve %s: %w", name, fs.ErrNotExist)
+}
+
+// Clear forgets the dirty migration name so the next run executes it again.
+// Undo whatever part of it did run first.
+func (m *Migrate) Clear(name string) error {
+	resolver, ok := m.migrator.(mdriver.Resolver)
+	if !ok {
+		return fmt.Errorf("clear: %w", mdriver.ErrUnsupported)
+	}
+	defer m.close()
+	return resolver.Clear(name)
+}
+
+// Restore puts back the latest snapshot a driver took before a run, returning
+// its path. Stop anything else using the database first.
+func (m *Migrate) Restore() (string, error) {
+	restorer, ok := m.migrator.(mdriver.Restorer)
+	if !ok {
+		return "", fmt.Errorf("restore: %w", mdriver.ErrUnsupported)
+	}
+	return restorer.Restore()
+}
+
+// close a driver used outside of a Begin, Commit or Rollback run.
+func (m *Migrate) close() {
+	if closer, ok := m.migrator.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// Lint plain migration files in dir for hazards specific to driver without
+// connecting to a database.
+func Lint(driver, dir string, opts ...lint.Option) ([]lint.Finding, error) {
+	return lint.Dir(driver, dir, opts...)
+}
+
+// LintFS lints plain migration files in dir of fsys for hazards specific to driver.
+func LintFS(driver string, fsys fs.FS, dir string, opts ...lint.Option) ([]lint.Finding, error) {
+	return lint.FS(driver, fsys, dir, opts...)
+}
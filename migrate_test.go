@@ -3,10 +3,14 @@ package migrate_test
 import (
 	"bytes"
 	"embed"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/shanna/migrate"
@@ -76,8 +80,41 @@ func (n *NameCapturingMigrator) Migrate(name string, data io.Reader) error {
 	return nil
 }
 
+// ResolvingMigrator records resolutions for testing Resolve and Clear.
+var resolved []string
+
+type ResolvingMigrator struct{ NameCapturingMigrator }
+
+func (r *ResolvingMigrator) Resolve(name string, data io.Reader) error {
+	bytes, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	resolved = append(resolved, "resolve "+name+" "+string(bytes))
+	return nil
+}
+
+func (r *ResolvingMigrator) Clear(name string) error {
+	resolved = append(resolved, "clear "+name)
+	return nil
+}
+
 // Tests
 
+// LoadingMigrator captures migrations and data file loads.
+var loaded []string
+
+type LoadingMigrator struct{ NameCapturingMigrator }
+
+func (l *LoadingMigrator) Load(name string, data io.Reader, load driver.Data) error {
+	bytes, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	loaded = append(loaded, fmt.Sprintf("%s %s %s %s", name, load.Format, load.Table, bytes))
+	return nil
+}
+
 func TestMigrate(t *testing.T) {
 	testdata := filepath.Join("_testdata")
 
@@ -188,3 +225,102 @@ func TestMigrateEmbedFS(t *testing.T) {
 		t.Errorf("migration doesn't match golden (-want +got):\n%s", diff)
 	}
 }
+
+func TestNewWithMigrator(t *testing.T) {
+	migrator := migrate.NewWithMigrator(&NameCapturingMigrator{}, migrate.WithNameFunc(func(path string) string {
+		return "custom:" + filepath.Base(path)
+	}))
+
+	if err := migrator.Dir(filepath.Join("_testdata", "input")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"custom:001-test.sql", "custom:002-test.sql", "custom:003-test.sh"}
+	if diff := cmp.Diff(want, capturedNames); diff != "" {
+		t.Errorf("names mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	resolved = nil
+	migrator := migrate.NewWithMigrator(&ResolvingMigrator{})
+	dir := filepath.Join("_testdata", "input")
+
+	if err := migrator.Resolve(dir, "001-test.sql"); err != nil {
+		t.Fatalf("resolve %s", err)
+	}
+	if err := migrator.Clear("003-test.sh"); err != nil {
+		t.Fatalf("clear %s", err)
+	}
+	if err := migrator.Resolve(dir, "003-test.sh"); err == nil {
+		t.Errorf("expected error resolving executable")
+	}
+	if err := migrator.Resolve(dir, "missing.sql"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+
+	golden, _ := os.ReadFile(filepath.Join(dir, "001-test.sql"))
+	want := []string{"resolve 001-test.sql " + string(golden), "clear 003-test.sh"}
+	if diff := cmp.Diff(want, resolved); diff != "" {
+		t.Errorf("resolved mismatch (-want +got):\n%s", diff)
+	}
+
+	unsupported := migrate.NewWithMigrator(&NameCapturingMigrator{})
+	if err := unsupported.Clear("001-test.sql"); !errors.Is(err, driver.ErrUnsupported) {
+		t.Errorf("expected unsupported error, got %v", err)
+	}
+}
+
+func TestResolveNameFunc(t *testing.T) {
+	dir := filepath.Join("_testdata", "input")
+	nameFunc := func(path string) string {
+		return filepath.Base(filepath.Dir(path)) + "/" + filepath.Base(path)
+	}
+
+	migrator := migrate.NewWithMigrator(&ResolvingMigrator{}, migrate.WithNameFunc(nameFunc))
+	if err := migrator.Dir(dir); err != nil {
+		t.Fatalf("dir %s", err)
+	}
+	name := capturedNames[0]
+
+	resolved = nil
+	if err := migrator.Resolve(dir, name); err != nil {
+		t.Fatalf("resolve %s %s", name, err)
+	}
+	golden, _ := os.ReadFile(filepath.Join(dir, "001-test.sql"))
+	want := []string{"resolve input/001-test.sql " + string(golden)}
+	if diff := cmp.Diff(want, resolved); diff != "" {
+		t.Errorf("resolved mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-schema.sql":           {Data: []byte("create table countries (code text);")},
+		"002-countries.csv":        {Data: []byte("code\nNZ\n")},
+		"002-countries.csv.table":  {Data: []byte("geo.countries\n")},
+		"003-feature_flags.ndjson": {Data: []byte(`{"name":"beta"}` + "\n")},
+	}
+
+	loaded = nil
+	migrator := migrate.NewWithMigrator(&LoadingMigrator{})
+	if err := migrator.DirFS(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"001-schema.sql"}, capturedNames); diff != "" {
+		t.Errorf("names mismatch (-want +got):\n%s", diff)
+	}
+	want := []string{
+		"002-countries.csv csv geo.countries code\nNZ\n",
+		"003-feature_flags.ndjson ndjson feature_flags {\"name\":\"beta\"}\n",
+	}
+	if diff := cmp.Diff(want, loaded); diff != "" {
+		t.Errorf("loaded mismatch (-want +got):\n%s", diff)
+	}
+
+	unsupported := migrate.NewWithMigrator(&NameCapturingMigrator{})
+	if err := unsupported.DirFS(fsys, "."); !errors.Is(err, driver.ErrUnsupported) {
+		t.Errorf("expected unsupported error, got %v", err)
+	}
+}
@@ -0,0 +1,534 @@
+// Package statement splits SQL scripts into individual statements.
+//
+// Splitting is lexical: semicolons inside quoted strings, quoted identifiers,
+// comments, dollar quoted bodies and trigger or BEGIN ATOMIC bodies do not end
+// a statement. Nothing is validated, the database still has the final say.
+//
+// Postgres COPY ... FROM STDIN statements are followed by their data up to a
+// line holding only \. as in pg_dump output. The data isn't split; read it
+// with Scanner.CopyData.
+package statement
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Dialect selects the quoting and comment rules used to split statements.
+type Dialect int
+
+const (
+	// Postgres supports '...' and E'...' strings, "..." identifiers, $tag$
+	// bodies, nested /* */ comments and BEGIN ATOMIC bodies.
+	Postgres Dialect = iota
+	// SQLite supports '...' strings, "...", `...` and [...] identifiers and
+	// trigger bodies.
+	SQLite
+	// DuckDB supports '...' strings, "..." identifiers and $tag$ bodies.
+	DuckDB
+	// ClickHouse supports '...' strings and "..." or `...` identifiers with
+	// backslash escapes, $tag$ heredocs and # comments.
+	ClickHouse
+	// Psql is Postgres plus psql meta-commands: a backslash outside quotes
+	// and comments starts a meta-command running to the end of the line.
+	Psql
+)
+
+// Statement is a single statement read from a script.
+type Statement struct {
+	// SQL as written including leading comments but without surrounding
+	// whitespace or the terminating semicolon.
+	SQL string
+	// Index is the 0-based position of the statement in the script.
+	Index int
+	// Line is the 1-based line SQL starts on.
+	Line int
+	// Column is the 1-based column SQL starts at on Line, counting characters
+	// rather than bytes.
+	Column int
+	// Offset is the byte offset SQL starts at.
+	Offset int64
+	// Copy is set on a Postgres COPY ... FROM STDIN whose data follows it in
+	// the script. Read the data with Scanner.CopyData before the next Scan.
+	Copy bool
+	// Meta is a psql meta-command such as \set or \gexec, backslash included,
+	// that ended the statement. SQL holds whatever query preceded it, if any.
+	Meta string
+}
+
+// Scanner reads statements from a stream one at a time so a script never has
+// to be held in memory as a whole.
+type Scanner struct {
+	r       *bufio.Reader
+	dialect Dialect
+	stmt    Statement
+	err     error
+	done    bool
+	copy    *copyReader // Data of the last statement if it was a COPY.
+
+	line   int
+	column int
+	offset int64
+	index  int
+}
+
+// NewScanner returns a Scanner reading statements in dialect from r.
+func NewScanner(r io.Reader, dialect Dialect) *Scanner {
+	return &Scanner{r: bufio.NewReader(r), dialect: dialect, line: 1}
+}
+
+// Statement returns the most recent statement read by Scan.
+func (s *Scanner) Statement() Statement {
+	return s.stmt
+}
+
+// Err returns the first non-EOF error encountered reading the stream.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Split a script held in memory.
+func Split(sql string, dialect Dialect) []Statement {
+	var statements []Statement
+	scanner := NewScanner(strings.NewReader(sql), dialect)
+	for scanner.Scan() {
+		statements = append(statements, scanner.Statement())
+	}
+	return statements
+}
+
+// LeadingComments returns the offset in sql of the first token after any
+// leading comments and whitespace, using the comment rules of dialect.
+func LeadingComments(sql string, dialect Dialect) int {
+	i := 0
+	for i < len(sql) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(sql[i])):
+			i++
+		case strings.HasPrefix(sql[i:], "--") || sql[i] == '#' && dialect == ClickHouse:
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return len(sql)
+			}
+			i += end
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth, j := 1, i+2
+			for depth > 0 {
+				if j >= len(sql) {
+					return len(sql)
+				}
+				switch {
+				case strings.HasPrefix(sql[j:], "*/"):
+					depth--
+					j += 2
+				case strings.HasPrefix(sql[j:], "/*") && (dialect == Postgres || dialect == Psql):
+					depth++
+					j += 2
+				default:
+					j++
+				}
+			}
+			i = j
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// Scan advances to the next statement, returning false at the end of the
+// stream or on error. Statements holding nothing but comments are skipped.
+func (s *Scanner) Scan() bool {
+	if s.copy != nil {
+		io.Copy(io.Discard, s.copy)
+		s.copy = nil
+	}
+
+	for !s.done && s.err == nil {
+		stmt, significant := s.next()
+		if significant {
+			stmt.Index = s.index
+			s.index++
+			if s.postgres() && stmt.Meta == "" && copyFromStdinRe.MatchString(stmt.SQL) {
+				stmt.Copy = true
+				s.copy = &copyReader{s: s}
+				s.skipLine()
+			}
+			s.stmt = stmt
+			return true
+		}
+	}
+	return false
+}
+
+// CopyData returns the data following the current COPY ... FROM STDIN
+// statement up to, but not including, the terminating \. line. It is empty
+// for any other statement.
+func (s *Scanner) CopyData() io.Reader {
+	if s.copy == nil {
+		return strings.NewReader("")
+	}
+	return s.copy
+}
+
+var copyFromStdinRe = regexp.MustCompile(`(?is)^(?:\s*(?:--[^\n]*|/\*.*?\*/))*\s*copy\s.*\sfrom\s+stdin\b`)
+
+// skipLine discards the rest of the line a COPY statement ends on since its
+// data starts on the next.
+func (s *Scanner) skipLine() {
+	for {
+		c, ok := s.read()
+		if !ok || c == '\n' {
+			return
+		}
+	}
+}
+
+// copyReader reads COPY data a line at a time up to a \. line.
+type copyReader struct {
+	s    *Scanner
+	line []byte
+	done bool
+}
+
+func (c *copyReader) Read(p []byte) (int, error) {
+	for len(c.line) == 0 {
+		if c.done || c.s.done {
+			return 0, io.EOF
+		}
+
+		line, err := c.s.r.ReadBytes('\n')
+		c.s.offset += int64(len(line))
+		c.s.line += bytes.Count(line, []byte{'\n'})
+		if bytes.HasSuffix(line, []byte{'\n'}) {
+			c.s.column = 0
+		} else {
+			c.s.column += utf8.RuneCount(line)
+		}
+		if err != nil {
+			c.s.done = true
+			if err != io.EOF {
+				c.s.err = err
+				return 0, err
+			}
+		}
+
+		if string(bytes.TrimRight(line, "\r\n")) == `\.` {
+			c.done = true
+			continue
+		}
+		c.line = line
+	}
+
+	n := copy(p, c.line)
+	c.line = c.line[n:]
+	return n, nil
+}
+
+func (s *Scanner) read() (byte, bool) {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		if err != io.EOF {
+			s.err = err
+		}
+		s.done = true
+		return 0, false
+	}
+	s.offset++
+	if c == '\n' {
+		s.line++
+		s.column = 0
+	} else if utf8.RuneStart(c) {
+		s.column++
+	}
+	return c, true
+}
+
+func (s *Scanner) peek() byte {
+	b, err := s.r.Peek(1)
+	if err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+// next reads up to and including the next terminating semicolon.
+func (s *Scanner) next() (Statement, bool) {
+	var (
+		buf         strings.Builder
+		stmt        Statement
+		word        strings.Builder
+		words       []string // leading words, enough to spot bodies.
+		last        string   // last word before the current position.
+		body        bool     // inside a trigger or BEGIN ATOMIC body.
+		cases       int      // open CASE expressions inside a body.
+		caseEnd     bool     // last was the END of a CASE rather than the body.
+		significant bool
+	)
+
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		prev := last
+		last = strings.ToLower(word.String())
+		word.Reset()
+		if len(words) < 4 {
+			words = append(words, last)
+		}
+		caseEnd = false
+		if body {
+			switch {
+			case last == "case":
+				cases++
+			case last == "end" && cases > 0:
+				cases--
+				caseEnd = true
+			}
+		}
+		if s.dialect == SQLite && !body && isTrigger(words) {
+			body = true
+		}
+		if s.postgres() && !body && prev == "begin" && last == "atomic" {
+			body = true
+		}
+	}
+
+	write := func(c byte) {
+		if buf.Len() == 0 {
+			stmt.Line, stmt.Column = s.line, s.column
+			stmt.Offset = s.offset - 1
+		}
+		buf.WriteByte(c)
+	}
+
+	for {
+		c, ok := s.read()
+		if !ok {
+			break
+		}
+
+		switch {
+		case c == '-' && s.peek() == '-', c == '#' && s.dialect == ClickHouse:
+			flush()
+			write(c)
+			for s.peek() != '\n' {
+				c, ok := s.read()
+				if !ok {
+					break
+				}
+				buf.WriteByte(c)
+			}
+
+		case c == '/' && s.peek() == '*':
+			flush()
+			write(c)
+			s.comment(&buf)
+
+		case c == '\'':
+			flush()
+			escapes := s.dialect == ClickHouse || s.postgres() && isEscapeString(buf.String())
+			write(c)
+			s.quoted(&buf, '\'', escapes)
+			significant, last = true, ""
+
+		case c == '"' || c == '`' && !s.postgres() && s.dialect != DuckDB:
+			flush()
+			write(c)
+			s.quoted(&buf, c, s.dialect == ClickHouse)
+			significant, last = true, ""
+
+		case c == '[' && s.dialect == SQLite:
+			flush()
+			write(c)
+			s.quoted(&buf, ']', false)
+			significant, last = true, ""
+
+		case c == '$' && s.dialect != SQLite && word.Len() == 0:
+			write(c)
+			if tag := s.tag(); tag != "" {
+				s.dollar(&buf, tag)
+			}
+			significant, last = true, ""
+
+		case c == '\\' && s.dialect == Psql:
+			flush()
+			if !significant {
+				buf.Reset()
+			}
+			stmt.SQL = strings.TrimRight(buf.String(), " \t\r\n")
+			if stmt.SQL == "" {
+				stmt.Line, stmt.Column, stmt.Offset = s.line, s.column, s.offset-1
+			}
+			stmt.Meta = s.meta()
+			return stmt, true
+
+		case c == ';':
+			flush()
+			if body && (last != "end" || caseEnd) {
+				buf.WriteByte(c)
+				last = ""
+				continue
+			}
+			stmt.SQL = strings.TrimRight(buf.String(), " \t\r\n")
+			return stmt, significant
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			flush()
+			if buf.Len() > 0 {
+				buf.WriteByte(c)
+			}
+
+		case isWord(c):
+			write(c)
+			word.WriteByte(c)
+			significant = true
+
+		default:
+			flush()
+			write(c)
+			significant, last = true, ""
+		}
+	}
+
+	flush()
+	stmt.SQL = strings.TrimRight(buf.String(), " \t\r\n")
+	return stmt, significant
+}
+
+// meta reads the rest of a psql meta-command after the backslash, up to but
+// not including the end of the line.
+func (s *Scanner) meta() string {
+	var buf strings.Builder
+	buf.WriteByte('\\')
+	for s.peek() != '\n' {
+		c, ok := s.read()
+		if !ok {
+			break
+		}
+		buf.WriteByte(c)
+	}
+	return strings.TrimRight(buf.String(), " \t\r")
+}
+
+// postgres reports whether the dialect follows Postgres quoting rules.
+func (s *Scanner) postgres() bool {
+	return s.dialect == Postgres || s.dialect == Psql
+}
+
+// comment reads the rest of a block comment after the opening slash.
+func (s *Scanner) comment(buf *strings.Builder) {
+	c, _ := s.read()
+	buf.WriteByte(c)
+
+	depth := 1
+	for depth > 0 {
+		c, ok := s.read()
+		if !ok {
+			return
+		}
+		buf.WriteByte(c)
+		switch {
+		case c == '*' && s.peek() == '/':
+			c, _ = s.read()
+			buf.WriteByte(c)
+			depth--
+		case c == '/' && s.peek() == '*' && s.postgres():
+			c, _ = s.read()
+			buf.WriteByte(c)
+			depth++
+		}
+	}
+}
+
+// quoted reads the rest of a quoted string or identifier after the opening
+// quote. A doubled closing quote is an escaped quote.
+func (s *Scanner) quoted(buf *strings.Builder, quote byte, escapes bool) {
+	for {
+		c, ok := s.read()
+		if !ok {
+			return
+		}
+		buf.WriteByte(c)
+		switch {
+		case escapes && c == '\\':
+			if c, ok := s.read(); ok {
+				buf.WriteByte(c)
+			}
+		case c == quote && s.peek() == quote && quote != ']':
+			c, _ = s.read()
+			buf.WriteByte(c)
+		case c == quote:
+			return
+		}
+	}
+}
+
+// tag reads the remainder of a $tag$ opening after the first dollar, returning
+// the whole tag or "" when the dollar is something else such as $1.
+func (s *Scanner) tag() string {
+	for n := 1; ; n++ {
+		peek, err := s.r.Peek(n)
+		if err != nil || len(peek) < n {
+			return ""
+		}
+		c := peek[n-1]
+		switch {
+		case c == '$':
+			tag := "$" + string(peek)
+			for range n {
+				s.read()
+			}
+			return tag
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 || n > 1 && c >= '0' && c <= '9':
+			continue
+		default:
+			return ""
+		}
+	}
+}
+
+// dollar reads a dollar quoted body up to and including the closing tag.
+func (s *Scanner) dollar(buf *strings.Builder, tag string) {
+	buf.WriteString(tag[1:])
+	start := buf.Len()
+	for {
+		c, ok := s.read()
+		if !ok {
+			return
+		}
+		buf.WriteByte(c)
+		if c == '$' && buf.Len()-start >= len(tag) && strings.HasSuffix(buf.String(), tag) {
+			return
+		}
+	}
+}
+
+func isWord(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// isEscapeString reports whether a quote following buf opens a Postgres
+// E'...' escape string.
+func isEscapeString(buf string) bool {
+	n := len(buf)
+	if n == 0 || buf[n-1] != 'e' && buf[n-1] != 'E' {
+		return false
+	}
+	return n == 1 || !isWord(buf[n-2])
+}
+
+// isTrigger reports whether leading words start a SQLite CREATE TRIGGER.
+func isTrigger(words []string) bool {
+	if len(words) < 2 || words[0] != "create" {
+		return false
+	}
+	if words[1] == "trigger" {
+		return true
+	}
+	return len(words) > 2 && (words[1] == "temp" || words[1] == "temporary") && words[2] == "trigger"
+}
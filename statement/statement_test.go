@@ -0,0 +1,211 @@
+package statement_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shanna/migrate/statement"
+)
+
+func sqls(statements []statement.Statement) []string {
+	list := []string{}
+	for _, stmt := range statements {
+		list = append(list, stmt.SQL)
+	}
+	return list
+}
+
+func TestSplit(t *testing.T) {
+	var tests = []struct {
+		name    string
+		dialect statement.Dialect
+		sql     string
+		want    []string
+	}{
+		{`single`, statement.Postgres, `select 1`, []string{`select 1`}},
+		{`multiple`, statement.Postgres, "select 1;\nselect 2;\n", []string{`select 1`, `select 2`}},
+		{`empty`, statement.Postgres, " ; ;\n-- only a comment\n", []string{}},
+		{`leading comment`, statement.Postgres, "-- one\nselect 1; /* two */ select 2", []string{"-- one\nselect 1", "/* two */ select 2"}},
+		{`comment semicolon`, statement.Postgres, "select 1 -- ;\n; select 2", []string{"select 1 -- ;", "select 2"}},
+		{`nested comment`, statement.Postgres, "/* a /* b; */ c; */ select 1; select 2", []string{"/* a /* b; */ c; */ select 1", "select 2"}},
+		{`string`, statement.Postgres, `select 'a;''b'; select 2`, []string{`select 'a;''b'`, `select 2`}},
+		{`escape string`, statement.Postgres, `select E'a\';b'; select 2`, []string{`select E'a\';b'`, `select 2`}},
+		{`identifier`, statement.Postgres, `select 1 as "a;b"; select 2`, []string{`select 1 as "a;b"`, `select 2`}},
+		{`dollar`, statement.Postgres, "create function f() returns int as $$ select 1; $$ language sql; select 2", []string{"create function f() returns int as $$ select 1; $$ language sql", "select 2"}},
+		{`dollar tag`, statement.Postgres, "do $body$ begin perform 1; end $body$; select $1", []string{"do $body$ begin perform 1; end $body$", "select $1"}},
+		{`begin atomic`, statement.Postgres, "create function f() returns int begin atomic select 1; end; select 2", []string{"create function f() returns int begin atomic select 1; end", "select 2"}},
+		{`sqlite trigger case`, statement.SQLite, "create trigger t after insert on a begin update b set c = case when new.x > 0 then 1 else 0 end; insert into d select case new.y when 1 then 'a' end; end; select 2", []string{"create trigger t after insert on a begin update b set c = case when new.x > 0 then 1 else 0 end; insert into d select case new.y when 1 then 'a' end; end", "select 2"}},
+		{`begin atomic case`, statement.Postgres, "create function f(x int) returns int begin atomic select case when x > 0 then 1 end; end; select 2", []string{"create function f(x int) returns int begin atomic select case when x > 0 then 1 end; end", "select 2"}},
+		{`sqlite trigger`, statement.SQLite, "create trigger t after insert on a begin insert into b values (1); update c set d = 1; end; select 2", []string{"create trigger t after insert on a begin insert into b values (1); update c set d = 1; end", "select 2"}},
+		{`sqlite identifiers`, statement.SQLite, "select [a;b], `c;d`; select 2", []string{"select [a;b], `c;d`", "select 2"}},
+		{`clickhouse backticks`, statement.ClickHouse, "SELECT 1 AS `a;b`; SELECT 'c\\';d'; SELECT 2", []string{"SELECT 1 AS `a;b`", "SELECT 'c\\';d'", "SELECT 2"}},
+		{`clickhouse hash comment`, statement.ClickHouse, "# comment;\nSELECT 1", []string{"# comment;\nSELECT 1"}},
+		{`clickhouse heredoc`, statement.ClickHouse, "SELECT $$a;b$$; SELECT 2", []string{"SELECT $$a;b$$", "SELECT 2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := sqls(statement.Split(test.sql, test.dialect))
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("statements mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScannerPosition(t *testing.T) {
+	sql := "select 1;\n\n  -- two\n  select\n2; select 'é'; select 4;"
+
+	statements := statement.Split(sql, statement.Postgres)
+	if len(statements) != 4 {
+		t.Fatalf("expected 4 statements, got %d", len(statements))
+	}
+
+	second := statements[1]
+	if second.Index != 1 || second.Line != 3 || second.Column != 3 {
+		t.Errorf("expected index 1 line 3 column 3, got index %d line %d column %d", second.Index, second.Line, second.Column)
+	}
+	if fourth := statements[3]; fourth.Line != 5 || fourth.Column != 16 {
+		t.Errorf("expected line 5 column 16, got line %d column %d", fourth.Line, fourth.Column)
+	}
+	if got := sql[second.Offset : second.Offset+int64(len(second.SQL))]; got != second.SQL {
+		t.Errorf("offset %d doesn't point at statement, got %q", second.Offset, got)
+	}
+}
+
+func TestScannerStream(t *testing.T) {
+	scanner := statement.NewScanner(iotest.OneByteReader(strings.NewReader("select 1; select $$2$$")), statement.Postgres)
+
+	var got []string
+	for scanner.Scan() {
+		got = append(got, scanner.Statement().SQL)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"select 1", "select $$2$$"}, got); diff != "" {
+		t.Errorf("statements mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestScannerError(t *testing.T) {
+	scanner := statement.NewScanner(iotest.TimeoutReader(strings.NewReader("select 1; select 2")), statement.Postgres)
+	for scanner.Scan() {
+	}
+	if scanner.Err() == nil {
+		t.Fatal("expected read error")
+	}
+}
+
+func TestScannerCopy(t *testing.T) {
+	sql := "-- Data for Name: users\n" +
+		"COPY public.users (id, bio) FROM stdin;\n" +
+		"1\tlikes; semicolons\n" +
+		"2\t'quotes' and $$dollars\n" +
+		"\\.\n" +
+		"\n" +
+		"COPY public.empty (id) FROM stdin;\n" +
+		"\\.\n" +
+		"select setval('users_id_seq', 2);\n" +
+		"copy users to stdout;\n"
+
+	var tests = []struct {
+		name string
+		read bool
+	}{
+		{`read`, true},
+		{`skipped`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scanner := statement.NewScanner(strings.NewReader(sql), statement.Postgres)
+
+			var got, data []string
+			for scanner.Scan() {
+				stmt := scanner.Statement()
+				got = append(got, fmt.Sprintf("%d %t %s", stmt.Line, stmt.Copy, stmt.SQL))
+				if test.read && stmt.Copy {
+					b, err := io.ReadAll(scanner.CopyData())
+					if err != nil {
+						t.Fatal(err)
+					}
+					data = append(data, string(b))
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{
+				"1 true -- Data for Name: users\nCOPY public.users (id, bio) FROM stdin",
+				"7 true COPY public.empty (id) FROM stdin",
+				"9 false select setval('users_id_seq', 2)",
+				"10 false copy users to stdout",
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("statements mismatch (-want +got):\n%s", diff)
+			}
+			if !test.read {
+				return
+			}
+			if diff := cmp.Diff([]string{"1\tlikes; semicolons\n2\t'quotes' and $$dollars\n", ""}, data); diff != "" {
+				t.Errorf("copy data mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScannerPsql(t *testing.T) {
+	sql := "\\set ON_ERROR_STOP on\n" +
+		"-- comment\n" +
+		"\\echo 'creating :name'\n" +
+		"select ':x\\y', E'\\\\', 1;\n" +
+		"select format('create table %I ()', n) from names \\gexec\n" +
+		"/* \\not_meta */ select 2;\n" +
+		"\\i other.sql"
+
+	var got []string
+	scanner := statement.NewScanner(strings.NewReader(sql), statement.Psql)
+	for scanner.Scan() {
+		stmt := scanner.Statement()
+		got = append(got, fmt.Sprintf("%d %q %q", stmt.Line, stmt.SQL, stmt.Meta))
+	}
+
+	want := []string{
+		`1 "" "\\set ON_ERROR_STOP on"`,
+		`3 "" "\\echo 'creating :name'"`,
+		`4 "select ':x\\y', E'\\\\', 1" ""`,
+		`5 "select format('create table %I ()', n) from names" "\\gexec"`,
+		`6 "/* \\not_meta */ select 2" ""`,
+		`7 "" "\\i other.sql"`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("statements mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLeadingComments(t *testing.T) {
+	tests := []struct {
+		sql     string
+		dialect statement.Dialect
+		want    string
+	}{
+		{"-- note\nalter table a", statement.SQLite, "alter table a"},
+		{"/* a */ /* b */\n  alter table a", statement.SQLite, "alter table a"},
+		{"# note\nalter table a", statement.ClickHouse, "alter table a"},
+		{"# note\nalter table a", statement.SQLite, "# note\nalter table a"},
+		{"/* a /* b */ c */ alter table a", statement.Postgres, "alter table a"},
+		{"/* unterminated", statement.Postgres, ""},
+	}
+	for _, test := range tests {
+		got := test.sql[statement.LeadingComments(test.sql, test.dialect):]
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("leading comments %q mismatch (-want +got):\n%s", test.sql, diff)
+		}
+	}
+}